@@ -0,0 +1,72 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+const auditFileLongParam string = "audit-file"
+
+// auditFile is the path of the audit log, set with --audit-file or in the configuration file
+var auditFile string
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Manage the audit log of the mutating commands",
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+}
+
+// newAuditRecorder returns the recorder of the operations performed as the
+// given cluster user in the audit log, nil if the audit log is not enabled
+func newAuditRecorder(kubeUser string) *audit.Recorder {
+	if auditFile == "" {
+		return nil
+	}
+
+	r := &audit.Recorder{Path: auditFile, OSUser: audit.OSUsername(), KubeUser: kubeUser}
+	if simulate == "" {
+		r.Context, _, _ = kube.GetCurrentContextNames()
+	}
+	return r
+}
+
+// newAuditedManager returns the identity Manager operating with the given client,
+// naming the user it is authenticated as in the events and recording the
+// operations changing identities in the audit log, if enabled.
+// The user is resolved only once, for both.
+func newAuditedManager(cmd *cobra.Command, cli kubernetes.Interface) *identity.Manager {
+	u := kube.GetUsername(cmd.Context(), cli)
+	if r := newAuditRecorder(u); r != nil {
+		return newManager(cli, identity.WithOperator(u), identity.WithAuditor(r))
+	}
+	return newManager(cli, identity.WithOperator(u))
+}
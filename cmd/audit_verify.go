@@ -0,0 +1,86 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/spf13/cobra"
+)
+
+// auditVerifyResult is the result of the verification of an audit log
+type auditVerifyResult struct {
+	File     string `json:"file"`
+	Records  int    `json:"records"`
+	LastHash string `json:"lastHash"`
+}
+
+func (r auditVerifyResult) name() string { return r.File }
+
+func (r auditVerifyResult) tableHeader() []string {
+	return []string{"FILE", "RECORDS", "LAST HASH"}
+}
+
+func (r auditVerifyResult) tableRow() []string {
+	return []string{r.File, strconv.Itoa(r.Records), r.LastHash}
+}
+
+// auditVerifyCmd represents the verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [file]",
+	Short: "Verify the audit log has not been tampered with",
+	Long: `Checks that each record of the audit log is chained to the previous one
+and matches its hash. The configured audit log is verified if no file is given.
+
+Removing the last records of the log can not be detected this way: compare the
+printed last hash with a copy stored elsewhere.
+
+The hashes are plain SHA-256, computed without any secret key: anyone who can
+write the log can rewrite it with a valid chain. Detect it by comparing the last
+hash with a copy stored elsewhere, or restrict write access to the log.`,
+	Args: cobra.MaximumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := auditFile
+		if len(args) == 1 {
+			f = args[0]
+		}
+		if f == "" {
+			return &usageError{err: errors.New("no audit log configured: set it with --" + auditFileLongParam + ", in the configuration file or as argument")}
+		}
+
+		s, err := audit.VerifyFile(f)
+		if err != nil {
+			return err
+		}
+		return printResults(cmd, auditVerifyResult{File: f, Records: s.Records, LastHash: s.LastHash})
+	},
+}
+
+func init() {
+	auditCmd.AddCommand(auditVerifyCmd)
+}
@@ -23,10 +23,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
-
-	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
 )
 
@@ -38,23 +34,28 @@ var beginRotationCmd = &cobra.Command{
 You create a new key and update your services with this new one.
 Finally, you remove the old one.
 
-This step creates the new key, without removing the old one.`,
-	Args: cobra.MatchAll(cobra.ExactArgs(1)),
+This step creates the new key, without removing the old one.
+It fails if the previous rotation has not been completed.`,
+	Args:              cobra.MatchAll(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeIdentity,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
 
 		ctx := cmd.Context()
 		name := args[0]
-		s, err := identity.BeginIdentityKeyRotation(ctx, *cli, name, namespace)
+		rs, err := newAuditedManager(cmd, cli).BeginRotation(ctx, name, namespace)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("created secret '%s/%s'\n", s.Namespace, s.Name)
-		return nil
+		r := newTokenVersionResult(&rs.Current, actionCreated)
+		return printResults(cmd, r)
 	},
 }
 
@@ -23,10 +23,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
-
-	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
 )
 
@@ -39,22 +35,26 @@ You create a new key and update your services with this new one.
 Finally, you remove the old one.
 
 This step deletes the old key`,
-	Args: cobra.MatchAll(cobra.ExactArgs(1)),
+	Args:              cobra.MatchAll(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeIdentity,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
 
 		ctx := cmd.Context()
 		name := args[0]
-		s, err := identity.CompleteIdentityKeyRotation(ctx, *cli, name, namespace)
+		tv, err := newAuditedManager(cmd, cli).CompleteRotation(ctx, name, namespace)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("deleted secret '%s/%s'\n", namespace, *s)
-		return nil
+		r := newTokenVersionResult(tv, actionDeleted)
+		return printResults(cmd, r)
 	},
 }
 
@@ -0,0 +1,155 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+// completionTimeout bounds the requests made to the cluster for completing
+// arguments, so that the shell is not blocked when the cluster is unreachable
+const completionTimeout = 3 * time.Second
+
+type completeFunc func(ctx context.Context, cli kubernetes.Interface, args []string) ([]string, error)
+
+// completeFromCluster runs the given function against the cluster selected
+// with the global flags, timing out quickly. Errors disable the completion.
+func completeFromCluster(f completeFunc) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		// persistent pre-runs are not executed when completing
+		if err := setup(); err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		cli, err := getClient(cmd)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+		defer cancel()
+
+		cc, err := f(ctx, cli, args)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return cc, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeIdentity completes the identity as the first argument
+var completeIdentity = completeFromCluster(func(ctx context.Context, cli kubernetes.Interface, args []string) ([]string, error) {
+	if len(args) != 0 {
+		return nil, nil
+	}
+	return listIdentityNames(ctx, cli)
+})
+
+// completeIdentities completes any number of identities, skipping the ones already provided
+var completeIdentities = completeFromCluster(func(ctx context.Context, cli kubernetes.Interface, args []string) ([]string, error) {
+	ii, err := listIdentityNames(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := make([]string, 0, len(ii))
+	for _, i := range ii {
+		if !containsString(args, i) {
+			cc = append(cc, i)
+		}
+	}
+	return cc, nil
+})
+
+// completeIdentityVersion completes the identity as the first argument and,
+// as the second one, the versions returned by the given function
+func completeIdentityVersion(versions func(m *identity.Manager, ctx context.Context, name string, namespace string) ([]uint64, error)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return completeFromCluster(func(ctx context.Context, cli kubernetes.Interface, args []string) ([]string, error) {
+		switch len(args) {
+		case 0:
+			return listIdentityNames(ctx, cli)
+		case 1:
+			vv, err := versions(newManager(cli), ctx, args[0], namespace)
+			if err != nil {
+				return nil, err
+			}
+
+			cc := make([]string, 0, len(vv))
+			for _, v := range vv {
+				cc = append(cc, strconv.FormatUint(v, 10))
+			}
+			return cc, nil
+		default:
+			return nil, nil
+		}
+	})
+}
+
+// completeNamespace completes the --namespace flag
+var completeNamespace = completeFromCluster(func(ctx context.Context, cli kubernetes.Interface, args []string) ([]string, error) {
+	return kube.ListNamespaces(ctx, cli)
+})
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// listIdentityNames returns the names of the identities in the namespace
+func listIdentityNames(ctx context.Context, cli kubernetes.Interface) ([]string, error) {
+	ii, err := newManager(cli).ListIdentities(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	nn := make([]string, 0, len(ii))
+	for _, i := range ii {
+		nn = append(nn, i.Name)
+	}
+	return nn, nil
+}
+
+// listTokenVersions returns the versions of the identity's existing tokens
+func listTokenVersions(m *identity.Manager, ctx context.Context, name string, namespace string) ([]uint64, error) {
+	tvs, err := m.ListTokenVersions(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	vv := make([]uint64, 0, len(tvs))
+	for _, tv := range tvs {
+		vv = append(vv, tv.Version)
+	}
+	return vv, nil
+}
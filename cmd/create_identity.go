@@ -23,11 +23,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"encoding/json"
-	"fmt"
-
-	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
 )
 
@@ -38,28 +33,33 @@ var createIdentityCmd = &cobra.Command{
 	Long: `A new service account and a first secret are created.
 
 The service account is named after the identity, whereas the secret name
-respect the following format '<identity>-key-<number>'.`,
+respect the naming template, by default '<identity>-key-<number>'.`,
 	Args: cobra.MatchAll(cobra.ExactArgs(1)),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := cfg.ValidateIdentityName(args[0]); err != nil {
+			return err
+		}
+		return validateOutput(outputJSON)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
 
 		ctx := cmd.Context()
 		name := args[0]
-		i, err := identity.CreateIdentity(ctx, *cli, name, namespace)
+		i, err := newAuditedManager(cmd, cli).CreateIdentity(ctx, name, namespace)
 		if err != nil {
 			return err
 		}
 
-		j, err := json.MarshalIndent(i, "", "  ")
-		if err != nil {
-			return err
+		r := identityResult{
+			Namespace:      i.Namespace,
+			ServiceAccount: i.Name,
+			Secrets:        []string{i.TokenVersions[0].Secret},
 		}
-		fmt.Println(string(j))
-
-		return nil
+		return printResults(cmd, r)
 	},
 }
 
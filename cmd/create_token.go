@@ -22,10 +22,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
-
-	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
 )
 
@@ -35,24 +31,28 @@ var createTokenCmd = &cobra.Command{
 	Short: "Create a new token for the given identity",
 	Long: `A new secret is created for the given identity.
 The name of the secret is built starting from the ones present on the cluster.
-Identity secrets respect the format <identity>-key-<number>.
-The new secret will have the name <identity>-key<number+1>.`,
-	Args: cobra.MatchAll(cobra.ExactArgs(1)),
+Identity secrets respect the naming template, by default <identity>-key-<number>.
+The new secret will have the name <identity>-key-<number+1>.`,
+	Args:              cobra.MatchAll(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeIdentity,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
 
 		ctx := cmd.Context()
 		name := args[0]
-		s, err := identity.CreateNewTokenVersion(ctx, *cli, name, namespace)
+		tv, err := newAuditedManager(cmd, cli).CreateTokenVersion(ctx, name, namespace)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("created secret '%s/%s'\n", s.Namespace, s.Name)
-		return nil
+		r := newTokenVersionResult(tv, actionCreated)
+		return printResults(cmd, r)
 	},
 }
 
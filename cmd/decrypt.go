@@ -0,0 +1,92 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filariow/kid/pkg/crypt"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const decryptIdentityLongParam string = "identity"
+
+var decryptIdentities []string
+
+// decryptCmd represents the decrypt command
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [<file>]",
+	Short: "Decrypt credentials encrypted with --encrypt-to",
+	Long: `Decrypts an age encrypted file, as produced by the --encrypt-to flag,
+and prints the plaintext to stdout.
+If no file is provided, the ciphertext is read from stdin.
+
+Identities can be age identity files or SSH private keys.
+If an SSH private key is encrypted, its passphrase is asked on the terminal.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ii, err := crypt.ParseIdentities(decryptIdentities, readPassphrase)
+		if err != nil {
+			return err
+		}
+
+		var d []byte
+		if len(args) == 1 {
+			d, err = os.ReadFile(args[0])
+		} else {
+			d, err = io.ReadAll(cmd.InOrStdin())
+		}
+		if err != nil {
+			return err
+		}
+
+		p, err := crypt.Decrypt(d, ii...)
+		if err != nil {
+			return err
+		}
+
+		_, err = cmd.OutOrStdout().Write(p)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(decryptCmd)
+
+	decryptCmd.Flags().StringArrayVarP(&decryptIdentities, decryptIdentityLongParam, "i", nil, "age identity file or SSH private key to decrypt with (can be repeated)")
+	_ = decryptCmd.MarkFlagRequired(decryptIdentityLongParam)
+}
+
+func readPassphrase() ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("can not ask for the SSH key passphrase: stdin is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, "Enter passphrase for SSH key: ")
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
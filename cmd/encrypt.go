@@ -0,0 +1,64 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/filariow/kid/pkg/crypt"
+	"github.com/spf13/pflag"
+)
+
+const encryptToLongParam string = "encrypt-to"
+
+var encryptTo []string
+
+func addEncryptToFlag(ff *pflag.FlagSet) {
+	ff.StringArrayVar(&encryptTo, encryptToLongParam, nil, "encrypt the output to the given age recipient, SSH public key or recipients file (can be repeated)")
+}
+
+// encryptOutput encrypts data to the recipients set with --encrypt-to.
+// If no recipient is set, data is returned as is.
+func encryptOutput(data []byte) ([]byte, error) {
+	if len(encryptTo) == 0 {
+		return data, nil
+	}
+
+	rr, err := crypt.ParseRecipients(encryptTo)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypt.Encrypt(data, rr...)
+}
+
+// printOutput writes data to stdout, encrypting it if requested
+func printOutput(data []byte) error {
+	o, err := encryptOutput(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(o)
+	return err
+}
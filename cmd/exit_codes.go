@@ -0,0 +1,149 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Exit codes, documented in the README: scripts rely on them, so they must not change
+const (
+	exitOK = 0
+	// exitError is returned for errors not covered by the other codes
+	exitError = 1
+	// exitUsage is returned for invalid flags or arguments
+	exitUsage = 2
+	// exitAlreadyExists is returned when the identity already exists
+	exitAlreadyExists = 3
+	// exitNotFound is returned when the identity or the token version does not exist
+	exitNotFound = 4
+	// exitVersionConflict is returned when the token version can not be created
+	exitVersionConflict = 5
+	// exitRotationInProgress is returned when beginning a rotation before completing the previous one
+	exitRotationInProgress = 6
+	// exitSecretMalformed is returned when a token secret is not the one expected
+	exitSecretMalformed = 7
+	// exitUnreachable is returned when the API server can not be reached or does not answer in time
+	exitUnreachable = 8
+	// exitPermissionDenied is returned when the API server rejects the credentials or the request
+	exitPermissionDenied = 9
+	// exitAuditTampered is returned when the audit log has been tampered with
+	exitAuditTampered = 10
+)
+
+// usageError is an error caused by invalid flags or arguments
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for the error returned by a command
+func exitCode(err error) int {
+	var (
+		ue *usageError
+		ne net.Error
+	)
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ue), isCobraUsageError(err):
+		return exitUsage
+	case errors.Is(err, identity.ErrAlreadyExists):
+		return exitAlreadyExists
+	case errors.Is(err, identity.ErrNotFound), errors.Is(err, kube.ErrSecretNotFound), kerrors.IsNotFound(err):
+		return exitNotFound
+	case errors.Is(err, identity.ErrVersionConflict):
+		return exitVersionConflict
+	case errors.Is(err, identity.ErrRotationInProgress):
+		return exitRotationInProgress
+	case errors.Is(err, identity.ErrSecretMalformed):
+		return exitSecretMalformed
+	case errors.Is(err, audit.ErrTampered):
+		return exitAuditTampered
+	case kerrors.IsUnauthorized(err), kerrors.IsForbidden(err):
+		return exitPermissionDenied
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne),
+		kerrors.IsTimeout(err), kerrors.IsServerTimeout(err), kerrors.IsServiceUnavailable(err), kerrors.IsTooManyRequests(err):
+		return exitUnreachable
+	default:
+		return exitError
+	}
+}
+
+// cobraUsageErrorPrefixes are the prefixes of the errors cobra returns for
+// unknown commands, missing required flags and flag group violations. Unlike
+// the flag parsing and arguments validation ones, they can not be wrapped.
+var cobraUsageErrorPrefixes = []string{
+	"unknown command ",
+	"required flag(s) ",
+	"if any flags in the group [",
+	"at least one of the flags in the group [",
+}
+
+// isCobraUsageError returns true if the error is one of the usage errors
+// returned by cobra that markUsageErrors can not wrap
+func isCobraUsageError(err error) bool {
+	for _, p := range cobraUsageErrorPrefixes {
+		if strings.HasPrefix(err.Error(), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// markUsageErrors makes the errors of the flags and arguments validation of
+// the command and its subcommands usage errors
+func markUsageErrors(c *cobra.Command) {
+	c.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &usageError{err: err}
+	})
+	if args := c.Args; args != nil {
+		c.Args = func(cmd *cobra.Command, aa []string) error {
+			if err := args(cmd, aa); err != nil {
+				return &usageError{err: err}
+			}
+			return nil
+		}
+	}
+
+	for _, sc := range c.Commands() {
+		markUsageErrors(sc)
+	}
+}
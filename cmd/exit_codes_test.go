@@ -0,0 +1,119 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestExitCode(t *testing.T) {
+	sa := schema.GroupResource{Resource: "serviceaccounts"}
+
+	tt := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{name: "no error", err: nil, code: exitOK},
+		{name: "generic", err: errors.New("error"), code: exitError},
+		{name: "usage", err: &usageError{err: errors.New("unknown flag")}, code: exitUsage},
+		{name: "identity exists", err: fmt.Errorf("wrapped: %w", identity.ErrIdentityExists), code: exitAlreadyExists},
+		{name: "identity not found", err: &identity.Error{Reason: identity.ErrIdentityNotFound, Cause: kerrors.NewNotFound(sa, "app")}, code: exitNotFound},
+		{name: "version not found", err: identity.ErrVersionNotFound, code: exitNotFound},
+		{name: "secret not found", err: kube.ErrSecretNotFound, code: exitNotFound},
+		{name: "version conflict", err: identity.ErrVersionConflict, code: exitVersionConflict},
+		{name: "rotation in progress", err: identity.ErrRotationInProgress, code: exitRotationInProgress},
+		{name: "secret malformed", err: fmt.Errorf("%w: no token", identity.ErrSecretMalformed), code: exitSecretMalformed},
+		{name: "audit log tampered", err: fmt.Errorf("%w: line 2", audit.ErrTampered), code: exitAuditTampered},
+		{name: "forbidden", err: kerrors.NewForbidden(sa, "app", errors.New("denied")), code: exitPermissionDenied},
+		{name: "unauthorized", err: kerrors.NewUnauthorized("invalid token"), code: exitPermissionDenied},
+		{name: "timeout", err: fmt.Errorf("request: %w", context.DeadlineExceeded), code: exitUnreachable},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, code: exitUnreachable},
+		{name: "service unavailable", err: kerrors.NewServiceUnavailable("unavailable"), code: exitUnreachable},
+		{name: "verify token rejected", err: fmt.Errorf("kubeconfig verification failed: %w", fmt.Errorf("%w: %w", identity.ErrKubeconfigTokenRejected, kerrors.NewUnauthorized("invalid token"))), code: exitPermissionDenied},
+		{name: "verify unreachable", err: fmt.Errorf("kubeconfig verification failed: %w", fmt.Errorf("%w: %w", identity.ErrKubeconfigUnreachable, &net.OpError{Op: "dial", Err: errors.New("connection refused")})), code: exitUnreachable},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if c := exitCode(tc.err); c != tc.code {
+				t.Errorf("expected exit code %d, found %d", tc.code, c)
+			}
+		})
+	}
+}
+
+func TestExitCodeCobraUsageErrors(t *testing.T) {
+	tt := []struct {
+		name string
+		args []string
+	}{
+		{name: "unknown command", args: []string{"nosuchcmd"}},
+		{name: "unknown flag", args: []string{"run", "--nosuchflag"}},
+		{name: "invalid arguments", args: []string{"run", "--name", "a", "x", "y"}},
+		{name: "required flag", args: []string{"run"}},
+		{name: "mutually exclusive flags", args: []string{"run", "--name", "a", "--dir", "d", "--file", "f"}},
+		{name: "flags required together", args: []string{"run", "--name", "a", "--user", "u"}},
+		{name: "one required flag", args: []string{"one"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "root", SilenceErrors: true, SilenceUsage: true}
+			run := &cobra.Command{Use: "run", Args: cobra.MaximumNArgs(1), RunE: func(*cobra.Command, []string) error { return nil }}
+			run.Flags().String("name", "", "")
+			run.Flags().String("dir", "", "")
+			run.Flags().String("file", "", "")
+			run.Flags().String("user", "", "")
+			run.Flags().String("password", "", "")
+			if err := run.MarkFlagRequired("name"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			run.MarkFlagsMutuallyExclusive("dir", "file")
+			run.MarkFlagsRequiredTogether("user", "password")
+			one := &cobra.Command{Use: "one", RunE: func(*cobra.Command, []string) error { return nil }}
+			one.Flags().String("a", "", "")
+			one.Flags().String("b", "", "")
+			one.MarkFlagsOneRequired("a", "b")
+			root.AddCommand(run, one)
+			markUsageErrors(root)
+
+			root.SetArgs(tc.args)
+			err := root.Execute()
+			if c := exitCode(err); c != exitUsage {
+				t.Errorf("expected exit code %d, found %d for error: %v", exitUsage, c, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/sealedsecret"
+	_ "github.com/filariow/kid/pkg/vault"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	exportFormatLongParam          string = "format"
+	exportSecretNameLongParam      string = "secret-name"
+	exportSecretNamespaceLongParam string = "secret-namespace"
+	exportClusterNameLongParam     string = "cluster-name"
+	exportServerUrlLongParam       string = "server-url"
+	exportTargetNamespaceLongParam string = "target-namespace"
+	exportToLongParam              string = "to"
+	exportSyncLongParam            string = "sync"
+	exportToContextLongParam       string = "to-context"
+	exportToSecretLongParam        string = "to-secret"
+	exportCertLongParam            string = "cert"
+	exportScopeLongParam           string = "scope"
+	exportSealedContentLongParam   string = "sealed-content"
+)
+
+var (
+	exportFormat          string
+	exportSecretName      string
+	exportSecretNamespace string
+	exportClusterName     string
+	exportServerUrl       string
+	exportTargetNamespace string
+	exportTo              string
+	exportSync            bool
+	exportToContext       string
+	exportToSecret        string
+	exportCert            string
+	exportScope           string
+	exportSealedContent   string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <identity>",
+	Short: "Export the credentials of an identity for CI systems and GitOps tools",
+	Long: `Exports the last token and the kubeconfig of the given identity in a format
+ready to be consumed by CI systems and GitOps tools.
+
+Supported formats are:
+  kubeconfig              the kubeconfig, this is the default
+  github-actions          dotenv file for 'gh secret set -f'
+  gitlab-ci               JSON list of variables for GitLab's variables API
+  argocd-cluster          Argo CD declarative cluster Secret
+  flux-kubeconfig-secret  Secret with the kubeconfig in the 'value' key, for Flux
+  sealed-secret           Bitnami SealedSecret holding the kubeconfig, or the token with
+                          --sealed-content token. It is encrypted offline with the
+                          controller's certificate provided with --cert
+
+The destination is selected with --to, by default credentials are printed to stdout.
+Supported destinations are:
+  stdout://               the rendered credentials are printed to stdout
+  file://<path>           the rendered credentials are written to the file,
+                          relative paths are expressed as 'file://./<path>'
+  vault://<mount>/<path>  HashiCorp Vault KV v2 secret, holding token, CA and kubeconfig.
+                          The token version is recorded in the secret's custom metadata.
+                          Vault is configured with the VAULT_ADDR, VAULT_CACERT and
+                          VAULT_NAMESPACE environment variables, and authentication uses
+                          VAULT_TOKEN or, if not set, AppRole with VAULT_ROLE_ID and VAULT_SECRET_ID.
+  secret://<ns>/<name>    Secret holding the kubeconfig in the 'kubeconfig' key, created or updated.
+                          The key can be changed with '?key=<key>' and the Secret can be written
+                          on the cluster of another kubeconfig context with '?context=<context>'.
+
+To copy the kubeconfig into a Secret on another cluster, --to-context and --to-secret
+can be used as a shorthand for 'secret://<ns>/<name>?context=<context>'.
+
+With --sync the credentials are exported again every time the last token of the
+identity changes, e.g. on rotation, until the command is interrupted.
+
+When the destination is not stdout, a result is printed for each export in the
+format selected with --output. With --sync only table, name and jsonl are supported,
+so that each export is printed as soon as it completes.`,
+	Args:              cobra.MatchAll(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeIdentity,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		ff := cmd.Flags()
+		if ff.Changed(exportToContextLongParam) && !ff.Changed(exportToSecretLongParam) {
+			return fmt.Errorf("--%s requires --%s", exportToContextLongParam, exportToSecretLongParam)
+		}
+
+		if ff.Changed(exportToSecretLongParam) {
+			ns, n, ok := strings.Cut(exportToSecret, "/")
+			if !ok || ns == "" || n == "" {
+				return fmt.Errorf("invalid --%s '%s': expected format is '<namespace>/<name>'", exportToSecretLongParam, exportToSecret)
+			}
+			exportTo = identity.SecretExporterURL(exportToContext, ns, n)
+		}
+
+		if exportSync && !ff.Changed(exportToLongParam) && !ff.Changed(exportToSecretLongParam) {
+			return fmt.Errorf("--%s requires --%s or --%s", exportSyncLongParam, exportToLongParam, exportToSecretLongParam)
+		}
+
+		if isExportToStdout() {
+			if output != "" {
+				return fmt.Errorf("--%s can not be used when exporting to stdout, use --%s to select the format", outputLongParam, exportFormatLongParam)
+			}
+			return nil
+		}
+		if exportSync {
+			return validateOutput(outputTable, outputTable, outputName, outputJSONL)
+		}
+		return validateOutput(outputTable)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cli, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		ro, err := exportRenderOptionsFromFlags(cmd.Flags())
+		if err != nil {
+			return err
+		}
+
+		eo := identity.ExporterOptions{Render: *ro}
+		if cmd.Flags().Changed(exportFormatLongParam) {
+			eo.Format = exportFormat
+		}
+		if len(encryptTo) > 0 {
+			eo.Encode = encryptOutput
+		}
+
+		e, err := identity.NewExporter(ctx, exportTo, eo)
+		if err != nil {
+			return err
+		}
+
+		return runExport(cmd, cli, args[0], e, exportKubeconfigOptionsFromFlags(cmd.Flags()))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportFormat, exportFormatLongParam, "f", identity.FormatKubeconfig, fmt.Sprintf("export format, one of: %s", strings.Join(identity.ExportFormats, "|")))
+	exportCmd.Flags().StringVar(&exportSecretName, exportSecretNameLongParam, "", "name of the rendered Secret")
+	exportCmd.Flags().StringVar(&exportSecretNamespace, exportSecretNamespaceLongParam, "", "namespace of the rendered Secret")
+	exportCmd.Flags().StringVar(&exportClusterName, exportClusterNameLongParam, "", "cluster name to register in Argo CD")
+	exportCmd.Flags().StringVarP(&exportServerUrl, exportServerUrlLongParam, "s", "", "if set overrides the cluster server URL")
+	exportCmd.Flags().StringVarP(&exportTargetNamespace, exportTargetNamespaceLongParam, "t", "", "Target namespace to set in kubeconfig")
+	exportCmd.Flags().StringVar(&exportTo, exportToLongParam, identity.StdoutScheme+"://", fmt.Sprintf("destination of the credentials, supported schemes: %s", strings.Join(identity.Exporters(), "|")))
+	exportCmd.Flags().BoolVar(&exportSync, exportSyncLongParam, false, "keep exporting the credentials every time the identity's token changes")
+	exportCmd.Flags().StringVar(&exportToContext, exportToContextLongParam, "", "kubeconfig context of the cluster where to write the Secret set with --to-secret")
+	exportCmd.Flags().StringVar(&exportToSecret, exportToSecretLongParam, "", "write the kubeconfig into the Secret '<namespace>/<name>', created or updated")
+	exportCmd.Flags().StringVar(&exportCert, exportCertLongParam, "", "PEM certificate of the sealed-secrets controller, as returned by 'kubeseal --fetch-cert'")
+	exportCmd.Flags().StringVar(&exportScope, exportScopeLongParam, sealedsecret.ScopeStrict, fmt.Sprintf("scope of the SealedSecret, one of: %s", strings.Join(sealedsecret.Scopes, "|")))
+	exportCmd.Flags().StringVar(&exportSealedContent, exportSealedContentLongParam, identity.SealedContentKubeconfig, "content of the SealedSecret, one of: kubeconfig|token")
+	addEncryptToFlag(exportCmd.Flags())
+	exportCmd.MarkFlagsMutuallyExclusive(exportToLongParam, exportToSecretLongParam)
+}
+
+func isExportToStdout() bool {
+	return exportTo == identity.StdoutScheme+"://"
+}
+
+func runExport(cmd *cobra.Command, cli kubernetes.Interface, name string, e identity.Exporter, opts identity.GetKubeconfigOptions) error {
+	p := newPrinter(output, cmd.OutOrStdout())
+	export := func(ctx context.Context, c *identity.Credentials) error {
+		if err := e.Export(ctx, c); err != nil {
+			return err
+		}
+
+		if isExportToStdout() {
+			return nil
+		}
+
+		r := exportResult{
+			Identity:    c.Identity,
+			Namespace:   c.Namespace,
+			Version:     c.Version,
+			Destination: exportTo,
+			Action:      actionExported,
+		}
+		if err := p.print(r); err != nil {
+			return err
+		}
+		if exportSync {
+			return p.flush()
+		}
+		return nil
+	}
+
+	ctx := cmd.Context()
+	if !exportSync {
+		c, err := newManager(cli).GetCredentials(ctx, name, namespace, opts)
+		if err != nil {
+			return err
+		}
+		if err := export(ctx, c); err != nil {
+			return err
+		}
+		return p.flush()
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := newManager(cli).SyncCredentials(ctx, name, namespace, opts, export)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func exportKubeconfigOptionsFromFlags(ff *pflag.FlagSet) identity.GetKubeconfigOptions {
+	o := identity.GetKubeconfigOptions{}
+
+	if ff.Changed(exportServerUrlLongParam) {
+		o.OverrideHost = &exportServerUrl
+	} else {
+		o.OverrideHost = getDefaultServerURL()
+	}
+
+	if ff.Changed(exportTargetNamespaceLongParam) {
+		o.Namespace = &exportTargetNamespace
+	}
+
+	return o
+}
+
+func exportRenderOptionsFromFlags(ff *pflag.FlagSet) (*identity.RenderOptions, error) {
+	o := identity.RenderOptions{
+		SealingScope:  exportScope,
+		SealedContent: exportSealedContent,
+	}
+
+	if ff.Changed(exportSecretNameLongParam) {
+		o.SecretName = &exportSecretName
+	}
+
+	if ff.Changed(exportSecretNamespaceLongParam) {
+		o.SecretNamespace = &exportSecretNamespace
+	}
+
+	if ff.Changed(exportClusterNameLongParam) {
+		o.ClusterName = &exportClusterName
+	}
+
+	if ff.Changed(exportCertLongParam) {
+		c, err := os.ReadFile(exportCert)
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := sealedsecret.ParsePublicKey(c)
+		if err != nil {
+			return nil, err
+		}
+		o.SealingKey = k
+	}
+
+	return &o, nil
+}
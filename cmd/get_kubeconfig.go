@@ -23,43 +23,92 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/filariow/kid/pkg/identity"
 	"github.com/filariow/kid/pkg/kube"
+	"github.com/filariow/kid/pkg/simulator"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
 )
 
+const serverURLEnvVar string = "KID_SERVER_URL"
+
 const (
 	getKubeconfigTargetNamespaceLongParam string = "target-namespace"
 	getKubeconfigServerUrlLongParam       string = "server-url"
-	getKubeconfigUserLongParam            string = "user"
+	getKubeconfigUserLongParam            string = "kubeconfig-user"
+	getKubeconfigVerifyLongParam          string = "verify"
+	getKubeconfigVerifyTimeoutLongParam   string = "verify-timeout"
+	getKubeconfigSelectorLongParam        string = "selector"
+	getKubeconfigOutDirLongParam          string = "out-dir"
+	getKubeconfigBundleLongParam          string = "bundle"
+
+	// getKubeconfigUserDeprecatedLongParam is the previous name of --kubeconfig-user,
+	// that shadows kubectl's global --user in this command
+	getKubeconfigUserDeprecatedLongParam string = "user"
 )
 
 var (
 	getKubeconfigTargetNamespace string
 	getKubeconfigServerUrl       string
 	getKubeconfigUser            string
+	getKubeconfigVerify          bool
+	getKubeconfigVerifyTimeout   time.Duration
+	getKubeconfigSelector        string
+	getKubeconfigOutDir          string
+	getKubeconfigBundle          string
 )
 
 // getKubeconfigCmd represents the kubeconfig command
 var getKubeconfigCmd = &cobra.Command{
-	Use:   "kubeconfig <identity>",
+	Use:   "kubeconfig [<identity>...]",
 	Short: "Display the kubeconfig for authenticating as the given identity",
 	Long: `Creates and prints to stdout the kubeconfig for authenticating as the given identity.
-The token embedded in the kubeconfig is the last one created.`,
-	Args: cobra.MatchAll(cobra.ExactArgs(1)),
+The token embedded in the kubeconfig is the last one created.
+
+If --verify is set, the kubeconfig is printed only after checking that the server
+can be reached with it and that it authenticates as the given identity.
+
+Kubeconfigs for many identities can be exported at once by passing more than one
+identity or by selecting them with --selector. With --out-dir a file named
+'<identity>.kubeconfig' is written for each identity, whereas with --bundle a single
+kubeconfig with one context per identity is written. If neither is set, the merged
+kubeconfig is printed to stdout.
+
+Printed kubeconfigs are formatted as yaml or json with --output, whereas when
+writing files the output describes the files written.`,
+	Args:              validateGetKubeconfigArgs,
+	ValidArgsFunction: completeIdentities,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if getKubeconfigOutDir != "" || getKubeconfigBundle != "" {
+			return validateOutput(outputTable)
+		}
+		return validateOutput(outputYAML, outputYAML, outputJSON)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
 
 		ctx := cmd.Context()
+		if len(args) != 1 || isGetKubeconfigBundle(cmd.Flags()) {
+			return runGetKubeconfigBundle(cmd, cli, args, getKubeconfigOptionsFromFlags(cmd.Flags()))
+		}
 
 		name := args[0]
-		s, err := kube.GetLastServiceAccountSecrets(ctx, *cli, name, namespace)
+		s, err := newManager(cli).GetLastTokenSecret(ctx, name, namespace)
 		if err != nil {
 			return err
 		}
@@ -68,16 +117,21 @@ The token embedded in the kubeconfig is the last one created.`,
 		if err != nil {
 			return err
 		}
+		warnIfTokenExpired(s)
 
 		o := getKubeconfigOptionsFromFlags(cmd.Flags())
-		kfg, err := identity.GetKubeconfig(*cli, tkn, o)
+		kfg, err := identity.GetKubeconfig(cli, tkn, o)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(string(kfg))
+		if getKubeconfigVerify {
+			if err := verifyKubeconfig(ctx, kfg, name); err != nil {
+				return err
+			}
+		}
 
-		return nil
+		return printKubeconfig(kfg)
 	},
 }
 
@@ -86,7 +140,196 @@ func init() {
 
 	getKubeconfigCmd.Flags().StringVarP(&getKubeconfigTargetNamespace, getKubeconfigTargetNamespaceLongParam, "t", "", "Target namespace to set in kubeconfig")
 	getKubeconfigCmd.Flags().StringVarP(&getKubeconfigServerUrl, getKubeconfigServerUrlLongParam, "s", "", "if set overrides the cluster server URL")
-	getKubeconfigCmd.Flags().StringVarP(&getKubeconfigUser, getKubeconfigUserLongParam, "u", "", "if set overrides the user")
+	getKubeconfigCmd.Flags().StringVarP(&getKubeconfigUser, getKubeconfigUserLongParam, "u", "", "if set overrides the user in the generated kubeconfig")
+	getKubeconfigCmd.Flags().StringVar(&getKubeconfigUser, getKubeconfigUserDeprecatedLongParam, "", "if set overrides the user in the generated kubeconfig")
+	_ = getKubeconfigCmd.Flags().MarkDeprecated(getKubeconfigUserDeprecatedLongParam, fmt.Sprintf("use --%s instead", getKubeconfigUserLongParam))
+	getKubeconfigCmd.Flags().BoolVar(&getKubeconfigVerify, getKubeconfigVerifyLongParam, false, "verify the kubeconfig against the cluster before printing it")
+	getKubeconfigCmd.Flags().DurationVar(&getKubeconfigVerifyTimeout, getKubeconfigVerifyTimeoutLongParam, 10*time.Second, "timeout for the kubeconfig verification")
+	getKubeconfigCmd.Flags().StringVarP(&getKubeconfigSelector, getKubeconfigSelectorLongParam, "l", "", "export the kubeconfigs of all the identities whose service accounts match the label selector, skipping the ones not managed by kid")
+	getKubeconfigCmd.Flags().StringVar(&getKubeconfigOutDir, getKubeconfigOutDirLongParam, "", "write one kubeconfig file per identity in the given directory")
+	getKubeconfigCmd.Flags().StringVar(&getKubeconfigBundle, getKubeconfigBundleLongParam, "", "write a single kubeconfig with one context per identity to the given file")
+	addEncryptToFlag(getKubeconfigCmd.Flags())
+	getKubeconfigCmd.MarkFlagsMutuallyExclusive(getKubeconfigOutDirLongParam, getKubeconfigBundleLongParam)
+}
+
+// getDefaultServerURL returns the server URL to set in generated kubeconfigs
+// when not provided with a flag. It is read from the KID_SERVER_URL environment
+// variable or from the configuration of the kubeconfig cluster, since in-cluster
+// the API server's address is not reachable from outside.
+func getDefaultServerURL() *string {
+	if u := os.Getenv(serverURLEnvVar); u != "" {
+		return &u
+	}
+
+	if simulate != "" {
+		u := simulator.ServerURL
+		return &u
+	}
+
+	if _, c, err := kube.GetCurrentContextNames(); err == nil {
+		if u := cfg.ClusterServerURL(c); u != "" {
+			return &u
+		}
+	}
+
+	if kube.IsInCluster() {
+		fmt.Fprintf(os.Stderr, "warning: running in-cluster, the kubeconfig will use the in-cluster API server address: set --%s or %s to the external one\n", getKubeconfigServerUrlLongParam, serverURLEnvVar)
+	}
+	return nil
+}
+
+func validateGetKubeconfigArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed(getKubeconfigSelectorLongParam) {
+		if len(args) > 0 {
+			return errors.New("identities can not be provided together with a selector")
+		}
+		return nil
+	}
+
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
+func isGetKubeconfigBundle(ff *pflag.FlagSet) bool {
+	return ff.Changed(getKubeconfigSelectorLongParam) ||
+		ff.Changed(getKubeconfigOutDirLongParam) ||
+		ff.Changed(getKubeconfigBundleLongParam)
+}
+
+func runGetKubeconfigBundle(cmd *cobra.Command, cli kubernetes.Interface, names []string, opts identity.GetKubeconfigOptions) error {
+	ctx := cmd.Context()
+	im := newManager(cli)
+	if getKubeconfigSelector != "" {
+		nn, err := im.ListIdentitiesBySelector(ctx, namespace, getKubeconfigSelector)
+		if err != nil {
+			return err
+		}
+		if len(nn) == 0 {
+			return fmt.Errorf("no identity matches selector '%s' in namespace '%s'", getKubeconfigSelector, namespace)
+		}
+		names = nn
+	}
+
+	kk, err := im.GetKubeconfigBundle(ctx, names, namespace, opts)
+	if err != nil {
+		return err
+	}
+
+	if getKubeconfigVerify || getKubeconfigOutDir != "" {
+		p := newPrinter(output, cmd.OutOrStdout())
+		for _, k := range kk {
+			kfg, err := clientcmd.Write(*k.Kubeconfig)
+			if err != nil {
+				return err
+			}
+
+			if getKubeconfigVerify {
+				if err := verifyKubeconfig(ctx, kfg, k.Identity); err != nil {
+					return fmt.Errorf("identity '%s/%s': %w", namespace, k.Identity, err)
+				}
+			}
+
+			if getKubeconfigOutDir != "" {
+				f, err := writeKubeconfigFile(getKubeconfigOutDir, k.Identity+".kubeconfig", kfg)
+				if err != nil {
+					return err
+				}
+				if err := p.print(newKubeconfigFileResult(f, k.Identity)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if getKubeconfigOutDir != "" {
+			return p.flush()
+		}
+	}
+
+	m, err := identity.MergeKubeconfigBundle(kk)
+	if err != nil {
+		return err
+	}
+
+	kfg, err := clientcmd.Write(*m)
+	if err != nil {
+		return err
+	}
+
+	if getKubeconfigBundle != "" {
+		d, f := filepath.Split(getKubeconfigBundle)
+		p, err := writeKubeconfigFile(d, f, kfg)
+		if err != nil {
+			return err
+		}
+
+		ii := make([]string, 0, len(kk))
+		for _, k := range kk {
+			ii = append(ii, k.Identity)
+		}
+		return printResults(cmd, newKubeconfigFileResult(p, ii...))
+	}
+
+	return printKubeconfig(kfg)
+}
+
+// printKubeconfig prints the kubeconfig to stdout in the selected output format
+func printKubeconfig(kfg []byte) error {
+	if output == outputJSON {
+		j, err := yaml.YAMLToJSON(kfg)
+		if err != nil {
+			return err
+		}
+
+		var b bytes.Buffer
+		if err := json.Indent(&b, j, "", "  "); err != nil {
+			return err
+		}
+		kfg = b.Bytes()
+	}
+
+	return printOutput(append(kfg, '\n'))
+}
+
+func verifyKubeconfig(ctx context.Context, kfg []byte, name string) error {
+	vctx, cancel := context.WithTimeout(ctx, getKubeconfigVerifyTimeout)
+	defer cancel()
+
+	if err := identity.VerifyKubeconfig(vctx, kfg, name, namespace); err != nil {
+		return fmt.Errorf("kubeconfig verification failed: %w", err)
+	}
+	return nil
+}
+
+// writeKubeconfigFile writes the kubeconfig to the given file, encrypting it if requested,
+// and returns the path of the written file. Encrypted files are suffixed with '.age'.
+func writeKubeconfigFile(dir string, name string, kfg []byte) (string, error) {
+	kfg, err := encryptOutput(kfg)
+	if err != nil {
+		return "", err
+	}
+	if len(encryptTo) > 0 {
+		name += ".age"
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+	}
+
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, kfg, 0o600); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func newKubeconfigFileResult(file string, identities ...string) kubeconfigFileResult {
+	return kubeconfigFileResult{
+		Identities: identities,
+		Namespace:  namespace,
+		File:       file,
+		Action:     actionWritten,
+	}
 }
 
 func getKubeconfigOptionsFromFlags(ff *pflag.FlagSet) identity.GetKubeconfigOptions {
@@ -98,9 +341,11 @@ func getKubeconfigOptionsFromFlags(ff *pflag.FlagSet) identity.GetKubeconfigOpti
 
 	if ff.Changed(getKubeconfigServerUrlLongParam) {
 		o.OverrideHost = &getKubeconfigServerUrl
+	} else {
+		o.OverrideHost = getDefaultServerURL()
 	}
 
-	if ff.Changed(getKubeconfigUserLongParam) {
+	if ff.Changed(getKubeconfigUserLongParam) || ff.Changed(getKubeconfigUserDeprecatedLongParam) {
 		o.User = &getKubeconfigUser
 	}
 
@@ -23,29 +23,121 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 )
 
+const (
+	getTokenFieldLongParam string = "field"
+
+	tokenOutputRaw    string = "raw"
+	tokenOutputEnv    string = "env"
+	tokenOutputDotenv string = "dotenv"
+
+	tokenFieldToken     string = "token"
+	tokenFieldCACrt     string = "ca.crt"
+	tokenFieldNamespace string = "namespace"
+)
+
+var getTokenField string
+
+var tokenFieldEnvVars = map[string]string{
+	tokenFieldToken:     "KID_TOKEN",
+	tokenFieldCACrt:     "KID_CA_CRT",
+	tokenFieldNamespace: "KID_NAMESPACE",
+}
+
+type tokenOutput struct {
+	Identity  string `json:"identity"`
+	Version   uint64 `json:"version"`
+	Secret    string `json:"secret"`
+	CACrt     string `json:"ca.crt"`
+	Namespace string `json:"namespace"`
+	Token     string `json:"token"`
+}
+
+func (o tokenOutput) name() string { return "secret/" + o.Secret }
+
+func (o tokenOutput) tableHeader() []string {
+	return []string{"NAMESPACE", "IDENTITY", "VERSION", "SECRET"}
+}
+
+func (o tokenOutput) tableRow() []string {
+	return []string{o.Namespace, o.Identity, strconv.FormatUint(o.Version, 10), o.Secret}
+}
+
+func (o tokenOutput) field(f string) string {
+	switch f {
+	case tokenFieldCACrt:
+		return o.CACrt
+	case tokenFieldNamespace:
+		return o.Namespace
+	default:
+		return o.Token
+	}
+}
+
 // getTokenCmd represents the token command
 var getTokenCmd = &cobra.Command{
 	Use:   "token <identity>",
 	Short: "Display the last token for the given identity",
-	Long:  `Fetches and prints to stdout the last token for the given identity.`,
-	Args:  cobra.MatchAll(cobra.ExactArgs(1)),
+	Long: `Fetches and prints to stdout the last token for the given identity.
+
+The output format is selected with --output:
+  json, yaml   the identity, the token version and the decoded token data
+  jsonl        the same data on a single line
+  table, name  the identity, the token version and the secret storing the token
+  raw          the bare value of the field selected with --field
+  env          shell 'export' statements, to be used with eval
+  dotenv       KEY=value lines, to be used as a .env file
+
+With --field only the given field is printed. If no output format is set,
+the bare value is printed, so that it can be piped to other commands:
+
+  kubectl --token="$(kid get token my-identity --field token)" get pods`,
+	Args:              cobra.MatchAll(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeIdentity,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		ff := cmd.Flags()
+		if ff.Changed(getTokenFieldLongParam) && output == "" {
+			output = tokenOutputRaw
+		}
+
+		if err := validateOutput(outputJSON, append(outputFormats, tokenOutputRaw, tokenOutputEnv, tokenOutputDotenv)...); err != nil {
+			return err
+		}
+
+		switch output {
+		case tokenOutputRaw, tokenOutputEnv, tokenOutputDotenv:
+		default:
+			if ff.Changed(getTokenFieldLongParam) {
+				return fmt.Errorf("--%s can not be used with output format '%s'", getTokenFieldLongParam, output)
+			}
+		}
+
+		if _, ok := tokenFieldEnvVars[getTokenField]; !ok {
+			return fmt.Errorf("invalid field '%s'", getTokenField)
+		}
+		return nil
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
 
 		ctx := cmd.Context()
 		name := args[0]
-		kdsec, err := kube.GetLastServiceAccountSecrets(ctx, *cli, name, namespace)
+		m := newManager(cli)
+		kdsec, err := m.GetLastTokenSecret(ctx, name, namespace)
 		if err != nil {
 			return err
 		}
@@ -54,18 +146,95 @@ var getTokenCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		warnIfTokenExpired(kdsec)
 
-		j, err := json.MarshalIndent(kd, "", "  ")
+		v, err := m.GetTokenVersion(kdsec)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(string(j))
+		o := tokenOutput{
+			Identity:  name,
+			Version:   v,
+			Secret:    kdsec.Name,
+			CACrt:     string(kd.CACrt),
+			Namespace: string(kd.Namespace),
+			Token:     string(kd.Token),
+		}
 
-		return nil
+		switch output {
+		case tokenOutputRaw, tokenOutputEnv, tokenOutputDotenv:
+			out := formatTokenOutput(o, output, cmd.Flags().Changed(getTokenFieldLongParam))
+			return printOutput([]byte(out + "\n"))
+		}
+
+		out, err := renderResults(output, o)
+		if err != nil {
+			return err
+		}
+		return printOutput(out)
 	},
 }
 
 func init() {
 	getCmd.AddCommand(getTokenCmd)
+
+	getTokenCmd.Flags().StringVar(&getTokenField, getTokenFieldLongParam, tokenFieldToken, "field to print, one of: token|ca.crt|namespace")
+	addEncryptToFlag(getTokenCmd.Flags())
+}
+
+// warnIfTokenExpired warns on stderr if the token stored in the secret is past
+// the expiration time recorded when it was created
+func warnIfTokenExpired(secret *corev1.Secret) {
+	t, err := identity.GetTokenExpiration(secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		return
+	}
+
+	if t != nil && time.Now().After(*t) {
+		fmt.Fprintf(os.Stderr, "warning: token '%s/%s' expired at %s, consider rotating it\n", secret.Namespace, secret.Name, t.Format(time.RFC3339))
+	}
+}
+
+func formatTokenOutput(o tokenOutput, format string, onlyField bool) string {
+	switch format {
+	case tokenOutputRaw:
+		return o.field(getTokenField)
+
+	default:
+		vv := map[string]string{tokenFieldEnvVars[getTokenField]: o.field(getTokenField)}
+		if !onlyField {
+			vv = map[string]string{
+				"KID_IDENTITY":      o.Identity,
+				"KID_TOKEN_VERSION": strconv.FormatUint(o.Version, 10),
+			}
+			for f, k := range tokenFieldEnvVars {
+				vv[k] = o.field(f)
+			}
+		}
+		return formatEnvVars(vv, format == tokenOutputEnv)
+	}
+}
+
+func formatEnvVars(vv map[string]string, export bool) string {
+	kk := make([]string, 0, len(vv))
+	for k := range vv {
+		kk = append(kk, k)
+	}
+	sort.Strings(kk)
+
+	ll := make([]string, 0, len(kk))
+	for _, k := range kk {
+		if export {
+			ll = append(ll, fmt.Sprintf("export %s=%s", k, shellQuote(vv[k])))
+		} else {
+			ll = append(ll, fmt.Sprintf("%s=%s", k, strconv.Quote(vv[k])))
+		}
+	}
+	return strings.Join(ll, "\n")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
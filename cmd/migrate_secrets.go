@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/spf13/cobra"
+)
+
+const (
+	migrateSecretsFromTemplateLongParam string = "from-template"
+	migrateSecretsDeleteOldLongParam    string = "delete-old"
+)
+
+var (
+	migrateSecretsFromTemplate string
+	migrateSecretsDeleteOld    bool
+)
+
+// migrateSecretsCmd represents the secrets command
+var migrateSecretsCmd = &cobra.Command{
+	Use:   "secrets <identity>",
+	Short: "Migrate the secrets of an identity to the configured naming template",
+	Long: `Creates a secret named after the configured naming template for each token
+version of the identity stored in a secret named after the template set with --from-template.
+
+Secrets can not be renamed: new secrets hold new tokens, issued by the cluster.
+Update the services using the identity with the new tokens, then delete the old
+secrets, revoking the old tokens, by running the command again with --delete-old.`,
+	Args:              cobra.MatchAll(cobra.ExactArgs(1)),
+	ValidArgsFunction: completeIdentity,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := identity.NewSecretNamer(migrateSecretsFromTemplate)
+		if err != nil {
+			return err
+		}
+
+		cli, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		name := args[0]
+		mm, err := newAuditedManager(cmd, cli).MigrateSecretNames(cmd.Context(), name, namespace, from, migrateSecretsDeleteOld)
+		rr := []result{}
+		for _, m := range mm {
+			if m.Created {
+				rr = append(rr, tokenVersionResult{Identity: name, Namespace: namespace, Version: m.Version, Secret: m.To, Action: actionCreated})
+			}
+			if m.Deleted {
+				rr = append(rr, tokenVersionResult{Identity: name, Namespace: namespace, Version: m.Version, Secret: m.From, Action: actionDeleted})
+			}
+		}
+		if perr := printResults(cmd, rr...); err == nil {
+			err = perr
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(rr) == 0 {
+			fmt.Fprintf(os.Stderr, "no secret of identity '%s/%s' to migrate\n", namespace, name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateSecretsCmd)
+
+	migrateSecretsCmd.Flags().StringVar(&migrateSecretsFromTemplate, migrateSecretsFromTemplateLongParam, identity.DefaultSecretNameTemplate, "naming template of the secrets to migrate")
+	migrateSecretsCmd.Flags().BoolVar(&migrateSecretsDeleteOld, migrateSecretsDeleteOldLongParam, false, "delete the secrets named after the old template, revoking their tokens")
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const outputLongParam string = "output"
+
+const (
+	outputJSON  string = "json"
+	outputYAML  string = "yaml"
+	outputTable string = "table"
+	outputName  string = "name"
+	outputJSONL string = "jsonl"
+)
+
+var outputFormats = []string{outputJSON, outputYAML, outputTable, outputName, outputJSONL}
+
+var output string
+
+// result is implemented by the typed results commands print
+type result interface {
+	// name identifies the result with -o name, e.g. '<kind>/<name>'
+	name() string
+	tableHeader() []string
+	tableRow() []string
+}
+
+// printer prints results in the selected output format.
+// Results are written as soon as they are printed with jsonl and name,
+// whereas table, json and yaml outputs are written on flush.
+// If more than one result is printed, json and yaml outputs are lists.
+type printer struct {
+	format string
+	w      io.Writer
+
+	tw    *tabwriter.Writer
+	items []result
+}
+
+func newPrinter(format string, w io.Writer) *printer {
+	return &printer{
+		format: format,
+		w:      w,
+		tw:     tabwriter.NewWriter(w, 0, 8, 3, ' ', 0),
+	}
+}
+
+func (p *printer) print(r result) error {
+	switch p.format {
+	case outputJSONL:
+		j, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(j))
+		return err
+
+	case outputName:
+		_, err := fmt.Fprintln(p.w, r.name())
+		return err
+
+	case outputTable:
+		if len(p.items) == 0 {
+			if _, err := fmt.Fprintln(p.tw, strings.Join(r.tableHeader(), "\t")); err != nil {
+				return err
+			}
+		}
+		p.items = append(p.items, r)
+		_, err := fmt.Fprintln(p.tw, strings.Join(r.tableRow(), "\t"))
+		return err
+
+	default:
+		p.items = append(p.items, r)
+		return nil
+	}
+}
+
+func (p *printer) flush() error {
+	switch p.format {
+	case outputTable:
+		return p.tw.Flush()
+
+	case outputJSON, outputYAML:
+		if len(p.items) == 0 {
+			return nil
+		}
+
+		var v interface{} = p.items
+		if len(p.items) == 1 {
+			v = p.items[0]
+		}
+
+		if p.format == outputYAML {
+			y, err := yaml.Marshal(v)
+			if err != nil {
+				return err
+			}
+			_, err = p.w.Write(y)
+			return err
+		}
+
+		j, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(j))
+		return err
+
+	default:
+		return nil
+	}
+}
+
+// printResults prints the results with the output format selected for the command
+func printResults(cmd *cobra.Command, rr ...result) error {
+	p := newPrinter(output, cmd.OutOrStdout())
+	for _, r := range rr {
+		if err := p.print(r); err != nil {
+			return err
+		}
+	}
+	return p.flush()
+}
+
+// renderResults returns the results formatted with the given output format
+func renderResults(format string, rr ...result) ([]byte, error) {
+	var b bytes.Buffer
+	p := newPrinter(format, &b)
+	for _, r := range rr {
+		if err := p.print(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// validateOutput sets the command's default output format, if none is
+// selected, and checks the selected one is supported.
+// The default is the one of the configuration file, if supported by the command.
+func validateOutput(defaultFormat string, supported ...string) error {
+	if len(supported) == 0 {
+		supported = outputFormats
+	}
+
+	if output == "" {
+		output = defaultFormat
+		if containsString(supported, cfg.Output) {
+			output = cfg.Output
+		}
+	}
+
+	if containsString(supported, output) {
+		return nil
+	}
+	return fmt.Errorf("invalid output format '%s', supported formats are: %s", output, strings.Join(supported, "|"))
+}
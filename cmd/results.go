@@ -0,0 +1,118 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/filariow/kid/pkg/identity"
+)
+
+const (
+	actionCreated    string = "created"
+	actionDeleted    string = "deleted"
+	actionRolledBack string = "rolled-back"
+	actionExported   string = "exported"
+	actionWritten    string = "written"
+)
+
+// identityResult is the result of the creation of an identity
+type identityResult identity.Instance
+
+func (r identityResult) name() string { return "serviceaccount/" + r.ServiceAccount }
+
+func (r identityResult) tableHeader() []string {
+	return []string{"NAMESPACE", "IDENTITY", "SECRETS"}
+}
+
+func (r identityResult) tableRow() []string {
+	return []string{r.Namespace, r.ServiceAccount, strings.Join(r.Secrets, ",")}
+}
+
+// tokenVersionResult is the result of commands creating or deleting a token version
+type tokenVersionResult struct {
+	Identity  string `json:"identity"`
+	Namespace string `json:"namespace"`
+	Version   uint64 `json:"version"`
+	Secret    string `json:"secret"`
+	Action    string `json:"action"`
+}
+
+func (r tokenVersionResult) name() string { return "secret/" + r.Secret }
+
+func (r tokenVersionResult) tableHeader() []string {
+	return []string{"NAMESPACE", "IDENTITY", "VERSION", "SECRET", "ACTION"}
+}
+
+func (r tokenVersionResult) tableRow() []string {
+	return []string{r.Namespace, r.Identity, strconv.FormatUint(r.Version, 10), r.Secret, r.Action}
+}
+
+// newTokenVersionResult builds the result for the token version
+func newTokenVersionResult(tv *identity.TokenVersion, action string) *tokenVersionResult {
+	return &tokenVersionResult{
+		Identity:  tv.Identity,
+		Namespace: tv.Namespace,
+		Version:   tv.Version,
+		Secret:    tv.Secret,
+		Action:    action,
+	}
+}
+
+// exportResult is the result of the export of an identity's credentials
+type exportResult struct {
+	Identity    string `json:"identity"`
+	Namespace   string `json:"namespace"`
+	Version     uint64 `json:"version"`
+	Destination string `json:"destination"`
+	Action      string `json:"action"`
+}
+
+func (r exportResult) name() string { return "serviceaccount/" + r.Identity }
+
+func (r exportResult) tableHeader() []string {
+	return []string{"NAMESPACE", "IDENTITY", "VERSION", "DESTINATION", "ACTION"}
+}
+
+func (r exportResult) tableRow() []string {
+	return []string{r.Namespace, r.Identity, strconv.FormatUint(r.Version, 10), r.Destination, r.Action}
+}
+
+// kubeconfigFileResult is the result of writing a kubeconfig to a file
+type kubeconfigFileResult struct {
+	Identities []string `json:"identities"`
+	Namespace  string   `json:"namespace"`
+	File       string   `json:"file"`
+	Action     string   `json:"action"`
+}
+
+func (r kubeconfigFileResult) name() string { return r.File }
+
+func (r kubeconfigFileResult) tableHeader() []string {
+	return []string{"NAMESPACE", "IDENTITIES", "FILE", "ACTION"}
+}
+
+func (r kubeconfigFileResult) tableRow() []string {
+	return []string{r.Namespace, strings.Join(r.Identities, ","), r.File, r.Action}
+}
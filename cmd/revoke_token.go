@@ -22,22 +22,23 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"strconv"
 
-	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
 )
 
 // revokeTokenCmd represents the token command
 var revokeTokenCmd = &cobra.Command{
-	Use:   "token <identity> <version>",
-	Short: "Revoke a token",
-	Long:  `Revokes the token with given version for the given identity`,
-	Args:  cobra.MatchAll(cobra.ExactArgs(2)),
+	Use:               "token <identity> <version>",
+	Short:             "Revoke a token",
+	Long:              `Revokes the token with given version for the given identity`,
+	Args:              cobra.MatchAll(cobra.ExactArgs(2)),
+	ValidArgsFunction: completeIdentityVersion(listTokenVersions),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
@@ -47,13 +48,13 @@ var revokeTokenCmd = &cobra.Command{
 			return err
 		}
 
-		s, err := identity.RevokeIdentityKey(cmd.Context(), *cli, args[0], namespace, uv)
+		tv, err := newAuditedManager(cmd, cli).RevokeTokenVersion(cmd.Context(), args[0], namespace, uv)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("secret deleted '%s/%s'\n", namespace, *s)
-		return nil
+		r := newTokenVersionResult(tv, actionDeleted)
+		return printResults(cmd, r)
 	},
 }
 
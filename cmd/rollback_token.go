@@ -22,22 +22,24 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"strconv"
 
 	"github.com/filariow/kid/pkg/identity"
-	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
 )
 
 // rollbackTokenCmd represents the token command
 var rollbackTokenCmd = &cobra.Command{
-	Use:   "token <identity> <version>",
-	Short: "Rollback a token",
-	Long:  `Rollback the token with a given version for the given identity.`,
-	Args:  cobra.ExactArgs(2),
+	Use:               "token <identity> <version>",
+	Short:             "Rollback a token",
+	Long:              `Rollback the token with a given version for the given identity.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeIdentityVersion((*identity.Manager).ListRevokedTokenVersions),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutput(outputTable)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cli, err := kube.GetCurrentContextClient()
+		cli, err := getClient(cmd)
 		if err != nil {
 			return err
 		}
@@ -47,13 +49,13 @@ var rollbackTokenCmd = &cobra.Command{
 			return err
 		}
 
-		s, err := identity.RollbackIdentityKey(cmd.Context(), *cli, args[0], namespace, uv)
+		tv, err := newAuditedManager(cmd, cli).RollbackTokenVersion(cmd.Context(), args[0], namespace, uv)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("secret rolled back '%s/%s'\n", s.Namespace, s.Name)
-		return nil
+		r := newTokenVersionResult(tv, actionRolledBack)
+		return printResults(cmd, r)
 	},
 }
 
@@ -23,14 +23,31 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/filariow/kid/pkg/config"
+	"github.com/filariow/kid/pkg/identity"
 	"github.com/filariow/kid/pkg/kube"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
 )
 
-var namespace string
+var (
+	namespace string
+	// configFlags are not persistent, as setup runs twice when completing:
+	// before and after the flags of the completed command are parsed
+	configFlags   = genericclioptions.NewConfigFlags(false)
+	configOptions kube.ConfigOptions
+	cfg           = &config.Config{}
+	// managerOptions configure the identity Manager, see newManager
+	managerOptions []identity.ManagerOption
+)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -43,22 +60,167 @@ This tool helps managing JWT tokens (create, revoke, rotate, rollback) and expor
 It uses heavily the convention over configuration paradigm and its not meant to
 provide a solid and constraining workflow.It gives you a lot of freedom, so be wise.
 Do not create tokens with the same version of revoked/leaked ones!`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setup()
+	},
+}
+
+// ExecuteAsKubectlPlugin executes the root command as the kubectl plugin 'kubectl kid'.
+// This is called by the main.main() of the kubectl-kid binary.
+func ExecuteAsKubectlPlugin() {
+	rootCmd.Annotations = map[string]string{
+		cobra.CommandDisplayNameAnnotation: "kubectl kid",
+	}
+	Execute()
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The exit code depends on the error, see exit_codes.go.
 func Execute() {
+	markUsageErrors(rootCmd)
 	err := rootCmd.Execute()
-	if err != nil {
-		os.Exit(1)
+	if serr := saveSimulation(); serr != nil {
+		fmt.Fprintf(os.Stderr, "Error: can not save the simulated cluster: %v\n", serr)
+		os.Exit(exitError)
 	}
+	os.Exit(exitCode(err))
 }
 
 func init() {
-	ns, err := kube.GetConfigDefaultNamespace()
+	ff := rootCmd.PersistentFlags()
+	addConfigFlags(ff)
+	addLogFlags(ff)
+	ff.StringVarP(&output, outputLongParam, "o", "", fmt.Sprintf("output format, one of: %s (get token also supports raw|env|dotenv)", strings.Join(outputFormats, "|")))
+	ff.StringVar(&auditFile, auditFileLongParam, "", "append the records of the mutating commands to the given audit log, overrides the configuration file")
+	ff.BoolVar(&configOptions.InCluster, "in-cluster", false, "use the service account of the Pod kid is running in, used by default if no kubeconfig is found")
+	if err := rootCmd.RegisterFlagCompletionFunc("namespace", completeNamespace); err != nil {
+		panic(err)
+	}
+	ff.StringVar(&simulate, simulateLongParam, "", "run against a simulated cluster stored in the given file instead of a real one")
+	if err := ff.MarkHidden(simulateLongParam); err != nil {
+		panic(err)
+	}
+	rootCmd.MarkFlagsMutuallyExclusive("in-cluster", "kubeconfig")
+	rootCmd.MarkFlagsMutuallyExclusive("in-cluster", "context")
+	rootCmd.MarkFlagsMutuallyExclusive("in-cluster", "cluster")
+	rootCmd.MarkFlagsMutuallyExclusive("in-cluster", "user")
+}
+
+// addConfigFlags adds kubectl's global flags, like --kubeconfig, --context,
+// --namespace and --as. The -s shorthand of --server is not registered,
+// as commands already use it for --server-url.
+func addConfigFlags(ff *pflag.FlagSet) {
+	configFlags.Namespace = &namespace
+
+	cf := pflag.NewFlagSet("kubectl", pflag.ContinueOnError)
+	configFlags.AddFlags(cf)
+	cf.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "server" {
+			f.Shorthand = ""
+		}
+		ff.AddFlag(f)
+	})
+}
+
+// addLogFlags adds klog's -v and --vmodule flags. The verbosity applies to kid's
+// logs and, as for kubectl, to client-go's ones: 6 logs the requests to the
+// API server, 8 their content.
+func addLogFlags(ff *pflag.FlagSet) {
+	gf := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(gf)
+	ff.AddGoFlag(gf.Lookup("v"))
+	ff.AddGoFlag(gf.Lookup("vmodule"))
+}
+
+// setup loads the configuration files and configures the packages with
+// the global flags and the loaded configuration
+func setup() error {
+	c, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg = c
+
+	if auditFile == "" {
+		auditFile = cfg.Audit.File
+	}
+
+	configOptions.Kubeconfig = *configFlags.KubeConfig
+	configOptions.Context = *configFlags.Context
+	configOptions.Cluster = *configFlags.ClusterName
+	configOptions.User = *configFlags.AuthInfoName
+	configOptions.Server = *configFlags.APIServer
+	configOptions.Impersonate = *configFlags.Impersonate
+	configOptions.ImpersonateGroups = *configFlags.ImpersonateGroup
+	configOptions.ImpersonateUID = *configFlags.ImpersonateUID
+	configOptions.Timeout = *configFlags.Timeout
+	configOptions.Loader = configFlags.ToRawKubeConfigLoader()
+	kube.SetConfigOptions(configOptions)
+
+	o := identity.Options{
+		Labels:      cfg.Identity.Labels,
+		Annotations: cfg.Identity.Annotations,
+	}
+	if cfg.Identity.TokenTTL != nil {
+		o.TokenTTL = cfg.Identity.TokenTTL.Duration
+	}
+	if cfg.Naming.SecretTemplate != "" {
+		n, err := identity.NewSecretNamer(cfg.Naming.SecretTemplate)
+		if err != nil {
+			return err
+		}
+		o.SecretNamer = n
+	}
+	managerOptions = []identity.ManagerOption{identity.WithOptions(o), identity.WithLogger(klog.Background())}
+	identity.SetLogger(klog.Background())
+	return nil
+}
+
+// newManager returns the identity Manager operating with the given client,
+// configured with the loaded configuration and the given options
+func newManager(cli kubernetes.Interface, opts ...identity.ManagerOption) *identity.Manager {
+	oo := append([]identity.ManagerOption{}, managerOptions...)
+	return identity.NewManager(cli, append(oo, opts...)...)
+}
+
+// getClient builds the client for the selected cluster and, if not set
+// with --namespace, resolves the namespace where to operate.
+// The kubeconfig is loaded only here, so that commands not calling it,
+// like help, completion and decrypt, work without a cluster.
+// With --simulate, the simulated cluster is returned.
+func getClient(cmd *cobra.Command) (kubernetes.Interface, error) {
+	if simulate != "" {
+		if !cmd.Flags().Changed("namespace") {
+			namespace = "default"
+		}
+		return getSimulatedClient()
+	}
+
+	cli, err := kube.GetCurrentContextClient()
 	if err != nil {
-		fmt.Fprint(os.Stderr, "can not parse namespace from kubeconfig, using default")
-		*ns = "default"
+		return nil, err
+	}
+
+	if !cmd.Flags().Changed("namespace") {
+		namespace = getDefaultNamespace()
+	}
+	return cli, nil
+}
+
+// getDefaultNamespace returns the namespace configured for the kubeconfig context
+// in the configuration file or, if not set, the one of the kubeconfig context
+func getDefaultNamespace() string {
+	if c, _, err := kube.GetCurrentContextNames(); err == nil {
+		if ns := cfg.ContextNamespace(c); ns != "" {
+			return ns
+		}
+	}
+
+	ns, err := kube.GetConfigDefaultNamespace()
+	if err != nil || *ns == "" {
+		fmt.Fprintln(os.Stderr, "can not parse namespace from kubeconfig, using default")
+		return "default"
 	}
-	rootCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", *ns, "the namespace where to operate")
+	return *ns
 }
@@ -0,0 +1,58 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"github.com/filariow/kid/pkg/simulator"
+)
+
+const simulateLongParam = "simulate"
+
+var (
+	// simulate is the file storing the state of the simulated cluster
+	simulate   string
+	simulation *simulator.Cluster
+)
+
+// getSimulatedClient returns the simulated cluster stored in the --simulate
+// file, loading it the first time it is requested
+func getSimulatedClient() (*simulator.Cluster, error) {
+	if simulation != nil {
+		return simulation, nil
+	}
+
+	c, err := simulator.LoadCluster(simulate, simulator.Options{})
+	if err != nil {
+		return nil, err
+	}
+	simulation = c
+	return c, nil
+}
+
+// saveSimulation stores the state of the simulated cluster, if it has been loaded
+func saveSimulation() error {
+	if simulation == nil {
+		return nil
+	}
+	return simulation.Save(simulate)
+}
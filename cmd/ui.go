@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/filariow/kid/pkg/ui"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const uiServerUrlLongParam string = "server-url"
+
+var uiServerUrl string
+
+// uiCmd represents the ui command
+var uiCmd = &cobra.Command{
+	Use:   "ui",
+	Short: "Browse and operate on identities in a terminal UI",
+	Long: `Opens a full-screen terminal UI listing namespaces, identities and their token versions.
+
+Key bindings:
+  tab     switch between the namespaces, identities and token versions panes
+  b       begin the rotation of the selected identity
+  c       complete the rotation of the selected identity
+  r       revoke the selected token version
+  o       roll back the selected revoked token version
+  k       view the kubeconfig of the selected identity
+  y       copy the kubeconfig of the selected identity to the clipboard
+  ctrl-r  refresh
+  q       quit
+
+Destructive actions are performed only after confirmation.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !term.IsTerminal(int(os.Stdout.Fd())) {
+			return errors.New("the terminal UI requires a terminal")
+		}
+
+		cli, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		u := kube.GetUsername(cmd.Context(), cli)
+		o := ui.Options{
+			Namespace:      namespace,
+			Kubeconfig:     identity.GetKubeconfigOptions{OverrideHost: getDefaultServerURL()},
+			ManagerOptions: append(append([]identity.ManagerOption{}, managerOptions...), identity.WithOperator(u)),
+			Audit:          newAuditRecorder(u),
+		}
+		if cmd.Flags().Changed(uiServerUrlLongParam) {
+			o.Kubeconfig.OverrideHost = &uiServerUrl
+		}
+
+		return ui.Run(cmd.Context(), cli, o)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(uiCmd)
+
+	uiCmd.Flags().StringVarP(&uiServerUrl, uiServerUrlLongParam, "s", "", "if set overrides the cluster server URL in kubeconfigs")
+}
@@ -0,0 +1,53 @@
+#!/usr/bin/env bash
+
+# Generates the krew manifest of the kubectl-kid plugin for a release.
+# Archives are expected in ARCHIVES_DIR, named kubectl-kid-<os>-<arch>.tar.gz.
+#
+# usage: generate-manifest.sh <version> <archives-dir>
+
+set -e -o pipefail
+
+VERSION=${1:?version is required}
+ARCHIVES_DIR=${2:?archives directory is required}
+REPOSITORY=${REPOSITORY:-filariow/kid}
+
+cat <<MANIFEST
+apiVersion: krew.googlecontainertools.github.com/v1alpha2
+kind: Plugin
+metadata:
+  name: kid
+spec:
+  version: ${VERSION}
+  homepage: https://github.com/${REPOSITORY}
+  shortDescription: Manage Service Account based identities and their tokens
+  description: |
+    KId (Kubernetes Identity) creates and manages identities backed by
+    Service Accounts. It creates, rotates, revokes and rolls back their
+    tokens, and exports kubeconfigs for them.
+  platforms:
+MANIFEST
+
+for archive in "${ARCHIVES_DIR}"/kubectl-kid-*.tar.gz; do
+    platform=$(basename "${archive}" .tar.gz)
+    platform=${platform#kubectl-kid-}
+    os=${platform%-*}
+    arch=${platform##*-}
+    bin=kubectl-kid
+    [ "${os}" == "windows" ] && bin=kubectl-kid.exe
+    sha=$(sha256sum "${archive}" | cut -d ' ' -f 1)
+
+    cat <<PLATFORM
+  - selector:
+      matchLabels:
+        os: ${os}
+        arch: ${arch}
+    uri: https://github.com/${REPOSITORY}/releases/download/${VERSION}/$(basename "${archive}")
+    sha256: ${sha}
+    bin: ${bin}
+    files:
+    - from: ${bin}
+      to: .
+    - from: LICENSE
+      to: .
+PLATFORM
+done
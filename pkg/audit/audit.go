@@ -0,0 +1,235 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package audit writes and verifies a tamper-evident log of kid's operations.
+//
+// The log is a JSON Lines file: each record holds the hash of the previous
+// one and its own hash, computed over its content. Changing, removing or
+// reordering records breaks the chain, which Verify detects. Removing the
+// last records is only detected by comparing the last hash with a copy
+// kept elsewhere.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Results of the audited operations
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Actions audited
+const (
+	ActionCreateIdentity   = "create-identity"
+	ActionCreateToken      = "create-token"
+	ActionBeginRotation    = "begin-rotation"
+	ActionCompleteRotation = "complete-rotation"
+	ActionRevokeToken      = "revoke-token"
+	ActionRollbackToken    = "rollback-token"
+	ActionMigrateSecrets   = "migrate-secrets"
+)
+
+// ErrTampered is returned by Verify when the hash chain is broken
+var ErrTampered = errors.New("audit log tampered")
+
+// maxRecordSize is the maximum size of a record, used to read the last one
+const maxRecordSize = 64 * 1024
+
+// Record describes an operation performed on an identity
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	// OSUser is the user of the operating system running kid
+	OSUser string `json:"osUser"`
+	// KubeUser is the user kid is authenticated as on the cluster
+	KubeUser  string `json:"kubeUser,omitempty"`
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace"`
+	Identity  string `json:"identity"`
+	// Version is the token version the operation is performed on, if known
+	Version *uint64 `json:"version,omitempty"`
+	Action  string  `json:"action"`
+	Result  string  `json:"result"`
+	// Reason is the error the operation failed with
+	Reason string `json:"reason,omitempty"`
+	// PreviousHash is the hash of the previous record, empty for the first one
+	PreviousHash string `json:"previousHash"`
+	// Hash is the hash of the record, computed with the Hash field empty
+	Hash string `json:"hash"`
+}
+
+// Summary describes a verified audit log
+type Summary struct {
+	Records  int    `json:"records"`
+	LastHash string `json:"lastHash"`
+}
+
+// computeHash returns the hash of the record, computed with the Hash field empty
+func (r Record) computeHash() (string, error) {
+	r.Hash = ""
+	d, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(d)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Append chains the record to the last one of the log at path and appends it.
+// The log and its directory are created if they do not exist.
+func Append(path string, r Record) (*Record, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// closing the file releases the lock
+	defer f.Close()
+
+	if err := lock(f); err != nil {
+		return nil, err
+	}
+
+	l, err := readLastRecord(f)
+	if err != nil {
+		return nil, fmt.Errorf("can not read the last record of audit log '%s': %w", path, err)
+	}
+
+	r.Timestamp = r.Timestamp.UTC()
+	r.PreviousHash = ""
+	if l != nil {
+		r.PreviousHash = l.Hash
+	}
+	if r.Hash, err = r.computeHash(); err != nil {
+		return nil, err
+	}
+
+	d, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(d, '\n')); err != nil {
+		return nil, err
+	}
+	return &r, f.Sync()
+}
+
+// Verify checks that each record of the log read from r is chained to the
+// previous one and that its hash matches its content
+func Verify(r io.Reader) (*Summary, error) {
+	s := &Summary{}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxRecordSize)
+	for l := 1; sc.Scan(); l++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+
+		rc, err := parseRecord(sc.Bytes())
+		if err != nil {
+			return s, fmt.Errorf("%w: line %d: %v", ErrTampered, l, err)
+		}
+		if rc.PreviousHash != s.LastHash {
+			return s, fmt.Errorf("%w: line %d: the record is not chained to the previous one", ErrTampered, l)
+		}
+
+		h, err := rc.computeHash()
+		if err != nil {
+			return s, err
+		}
+		if h != rc.Hash {
+			return s, fmt.Errorf("%w: line %d: the record does not match its hash", ErrTampered, l)
+		}
+
+		s.Records++
+		s.LastHash = rc.Hash
+	}
+	return s, sc.Err()
+}
+
+// VerifyFile checks the hash chain of the log at path
+func VerifyFile(path string) (*Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Verify(f)
+}
+
+// parseRecord parses a record, rejecting unknown fields, as they are not
+// covered by the hash
+func parseRecord(d []byte) (*Record, error) {
+	dec := json.NewDecoder(bytes.NewReader(d))
+	dec.DisallowUnknownFields()
+
+	r := &Record{}
+	if err := dec.Decode(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readLastRecord returns the last record of the log, or nil if it is empty
+func readLastRecord(f *os.File) (*Record, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	n := st.Size()
+	if n > maxRecordSize {
+		n = maxRecordSize
+	}
+	d := make([]byte, n)
+	if _, err := f.ReadAt(d, st.Size()-n); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	d = bytes.TrimRight(d, "\n\r\t ")
+	if len(d) == 0 {
+		return nil, nil
+	}
+
+	i := bytes.LastIndexByte(d, '\n')
+	if i < 0 && st.Size() > maxRecordSize {
+		return nil, fmt.Errorf("record longer than %d bytes", maxRecordSize)
+	}
+	return parseRecord(d[i+1:])
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRecord(action string, version uint64) Record {
+	return Record{
+		Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		OSUser:    "alice",
+		KubeUser:  "admin",
+		Context:   "prod",
+		Namespace: "test-ns",
+		Identity:  "app",
+		Version:   &version,
+		Action:    action,
+		Result:    ResultSuccess,
+	}
+}
+
+// appendTestRecords appends three records to a new log and returns its path
+func appendTestRecords(t *testing.T) (string, []Record) {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), "kid", "audit.jsonl")
+	rr := []Record{}
+	for i, a := range []string{ActionCreateIdentity, ActionBeginRotation, ActionCompleteRotation} {
+		r, err := Append(p, newTestRecord(a, uint64(i+1)))
+		if err != nil {
+			t.Fatalf("unexpected error appending record: %v", err)
+		}
+		rr = append(rr, *r)
+	}
+	return p, rr
+}
+
+func TestAppend(t *testing.T) {
+	p, rr := appendTestRecords(t)
+
+	if rr[0].PreviousHash != "" {
+		t.Errorf("expected the first record not to have a previous hash, found '%s'", rr[0].PreviousHash)
+	}
+	for i := 1; i < len(rr); i++ {
+		if rr[i].PreviousHash != rr[i-1].Hash {
+			t.Errorf("expected record %d to be chained to '%s', found '%s'", i, rr[i-1].Hash, rr[i].PreviousHash)
+		}
+	}
+
+	st, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := st.Mode().Perm(); m != 0600 {
+		t.Errorf("expected audit log mode 0600, found %o", m)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, rr := appendTestRecords(t)
+
+	s, err := VerifyFile(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Records != len(rr) || s.LastHash != rr[len(rr)-1].Hash {
+		t.Errorf("expected %d records and last hash '%s', found %d and '%s'", len(rr), rr[len(rr)-1].Hash, s.Records, s.LastHash)
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	s, err := Verify(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Records != 0 || s.LastHash != "" {
+		t.Errorf("expected no records, found %d", s.Records)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tt := []struct {
+		name   string
+		tamper func(ll [][]byte) [][]byte
+	}{
+		{
+			name: "changed field",
+			tamper: func(ll [][]byte) [][]byte {
+				ll[1] = bytes.Replace(ll[1], []byte(`"osUser":"alice"`), []byte(`"osUser":"mallory"`), 1)
+				return ll
+			},
+		},
+		{
+			name: "added field",
+			tamper: func(ll [][]byte) [][]byte {
+				ll[1] = bytes.Replace(ll[1], []byte(`{`), []byte(`{"note":"x",`), 1)
+				return ll
+			},
+		},
+		{
+			name: "removed record",
+			tamper: func(ll [][]byte) [][]byte {
+				return append(ll[:1], ll[2:]...)
+			},
+		},
+		{
+			name: "reordered records",
+			tamper: func(ll [][]byte) [][]byte {
+				ll[1], ll[2] = ll[2], ll[1]
+				return ll
+			},
+		},
+		{
+			name: "invalid record",
+			tamper: func(ll [][]byte) [][]byte {
+				ll[0] = []byte("not json")
+				return ll
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _ := appendTestRecords(t)
+			d, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ll := tc.tamper(bytes.Split(bytes.TrimSpace(d), []byte("\n")))
+			if _, err := Verify(bytes.NewReader(bytes.Join(ll, []byte("\n")))); !errors.Is(err, ErrTampered) {
+				t.Errorf("expected error %v, found: %v", ErrTampered, err)
+			}
+		})
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(v uint64) {
+			defer wg.Done()
+			if _, err := Append(p, newTestRecord(ActionCreateToken, v)); err != nil {
+				t.Errorf("unexpected error appending record: %v", err)
+			}
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+
+	s, err := VerifyFile(p)
+	if err != nil {
+		t.Fatalf("expected the concurrently appended records to be chained, found: %v", err)
+	}
+	if s.Records != 10 {
+		t.Errorf("expected 10 records, found %d", s.Records)
+	}
+}
+
+func TestAppendWaitsForLock(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lock(f); err != nil {
+		t.Fatalf("unexpected error locking the log: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := Append(p, newTestRecord(ActionRevokeToken, 1))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the append to wait for the lock, found it done with: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	f.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error appending record once the lock is released: %v", err)
+	}
+}
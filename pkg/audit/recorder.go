@@ -0,0 +1,59 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package audit
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+)
+
+// Recorder appends the records of the operations to the audit log at Path,
+// setting in each one the users performing them and the kubeconfig context
+type Recorder struct {
+	// Path is the path of the audit log
+	Path string
+	// OSUser is the user of the operating system running kid
+	OSUser string
+	// KubeUser is the user kid is authenticated as on the cluster
+	KubeUser string
+	// Context is the kubeconfig context kid operates in
+	Context string
+}
+
+// Audit appends the record to the audit log
+func (r *Recorder) Audit(rec Record) error {
+	rec.OSUser, rec.KubeUser, rec.Context = r.OSUser, r.KubeUser, r.Context
+	if _, err := Append(r.Path, rec); err != nil {
+		return fmt.Errorf("can not append to audit log '%s': %w", r.Path, err)
+	}
+	return nil
+}
+
+// OSUsername returns the name of the user of the operating system running kid
+func OSUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,245 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/filariow/kid/pkg/kube"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// EnvVar overrides the path of the user configuration file
+	EnvVar = "KID_CONFIG"
+	// ProjectFileName is the name of the project configuration file, looked up
+	// in the working directory and its parents
+	ProjectFileName = ".kid.yaml"
+)
+
+// Config holds the defaults of kid. Command line flags override them.
+type Config struct {
+	// Contexts holds the settings for each kubeconfig context, by name
+	Contexts map[string]Context `json:"contexts,omitempty"`
+	// Clusters holds the settings for each kubeconfig cluster, by name
+	Clusters map[string]Cluster `json:"clusters,omitempty"`
+	// Identity holds the defaults applied to created identities and tokens
+	Identity Identity `json:"identity,omitempty"`
+	// Output is the default output format
+	Output string `json:"output,omitempty"`
+	// Naming holds the naming conventions
+	Naming Naming `json:"naming,omitempty"`
+	// Audit holds the settings of the audit log
+	Audit Audit `json:"audit,omitempty"`
+}
+
+type Context struct {
+	// Namespace is the namespace where to operate
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type Cluster struct {
+	// ServerURL is the server URL set in generated kubeconfigs
+	ServerURL string `json:"serverURL,omitempty"`
+}
+
+type Identity struct {
+	// Labels are set on created service accounts and secrets
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are set on created service accounts and secrets
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// TokenTTL is recorded on created tokens as their expiration time
+	TokenTTL *metav1.Duration `json:"tokenTTL,omitempty"`
+}
+
+type Naming struct {
+	// IdentityPattern is a regular expression new identity names have to match
+	IdentityPattern string `json:"identityPattern,omitempty"`
+	// SecretTemplate is the template for the names of the secrets storing the tokens
+	SecretTemplate string `json:"secretTemplate,omitempty"`
+}
+
+type Audit struct {
+	// File is the path of the audit log, if empty mutating commands are not audited
+	File string `json:"file,omitempty"`
+}
+
+// Load reads the user configuration file and merges the project one on top of it.
+// Missing files are ignored, unless the user one is set with KID_CONFIG.
+func Load() (*Config, error) {
+	c := &Config{}
+
+	if p := os.Getenv(EnvVar); p != "" {
+		uc, err := LoadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		c = uc
+	} else if p, err := UserConfigPath(); err == nil {
+		uc, err := LoadFile(p)
+		switch {
+		case err == nil:
+			c = uc
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	if p, ok := FindProjectConfig(); ok {
+		pc, err := LoadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		c.merge(pc)
+	}
+
+	return c, nil
+}
+
+// LoadFile reads and validates the configuration file at the given path
+func LoadFile(path string) (*Config, error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(d, c); err != nil {
+		return nil, fmt.Errorf("invalid configuration file '%s': %w", path, err)
+	}
+
+	if c.Naming.IdentityPattern != "" {
+		if _, err := regexp.Compile(c.Naming.IdentityPattern); err != nil {
+			return nil, fmt.Errorf("invalid configuration file '%s': invalid identity pattern: %w", path, err)
+		}
+	}
+	return c, nil
+}
+
+// UserConfigPath returns the path of the user configuration file,
+// $XDG_CONFIG_HOME/kid/config.yaml or $HOME/.config/kid/config.yaml
+func UserConfigPath() (string, error) {
+	d := os.Getenv("XDG_CONFIG_HOME")
+	if d == "" {
+		h, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		d = filepath.Join(h, ".config")
+	}
+
+	return filepath.Join(d, "kid", "config.yaml"), nil
+}
+
+// FindProjectConfig looks for the project configuration file in the
+// working directory and in its parents
+func FindProjectConfig() (string, bool) {
+	d, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+
+	for {
+		p := filepath.Join(d, ProjectFileName)
+		if _, err := os.Stat(p); err == nil {
+			return p, true
+		}
+
+		pd := filepath.Dir(d)
+		if pd == d {
+			return "", false
+		}
+		d = pd
+	}
+}
+
+// ContextNamespace returns the namespace configured for the given context, if any
+func (c *Config) ContextNamespace(context string) string {
+	return c.Contexts[context].Namespace
+}
+
+// ClusterServerURL returns the server URL configured for the given cluster, if any
+func (c *Config) ClusterServerURL(cluster string) string {
+	return c.Clusters[cluster].ServerURL
+}
+
+// ValidateIdentityName checks the name matches the configured identity pattern
+func (c *Config) ValidateIdentityName(name string) error {
+	if c.Naming.IdentityPattern == "" {
+		return nil
+	}
+
+	m, err := regexp.MatchString(c.Naming.IdentityPattern, name)
+	if err != nil {
+		return err
+	}
+	if !m {
+		return fmt.Errorf("identity name '%s' does not match the configured pattern '%s'", name, c.Naming.IdentityPattern)
+	}
+	return nil
+}
+
+// merge sets on c the values set in o
+func (c *Config) merge(o *Config) {
+	for k, v := range o.Contexts {
+		if c.Contexts == nil {
+			c.Contexts = map[string]Context{}
+		}
+		c.Contexts[k] = v
+	}
+
+	for k, v := range o.Clusters {
+		if c.Clusters == nil {
+			c.Clusters = map[string]Cluster{}
+		}
+		c.Clusters[k] = v
+	}
+
+	c.Identity.Labels = kube.MergeStringMaps(c.Identity.Labels, o.Identity.Labels)
+	c.Identity.Annotations = kube.MergeStringMaps(c.Identity.Annotations, o.Identity.Annotations)
+	if o.Identity.TokenTTL != nil {
+		c.Identity.TokenTTL = o.Identity.TokenTTL
+	}
+
+	if o.Output != "" {
+		c.Output = o.Output
+	}
+
+	if o.Naming.IdentityPattern != "" {
+		c.Naming.IdentityPattern = o.Naming.IdentityPattern
+	}
+
+	if o.Naming.SecretTemplate != "" {
+		c.Naming.SecretTemplate = o.Naming.SecretTemplate
+	}
+
+	if o.Audit.File != "" {
+		c.Audit.File = o.Audit.File
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes the content to the file at the given path, creating its directory
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error writing '%s': %v", path, err)
+	}
+}
+
+// chdir changes the working directory for the duration of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error getting the working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("unexpected error changing directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// setupDirs sets the home and the working directory to empty temporary
+// directories, and clears the variables selecting the user configuration file.
+// It returns the home directory.
+func setupDirs(t *testing.T) string {
+	t.Helper()
+
+	h := t.TempDir()
+	t.Setenv("HOME", h)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv(EnvVar, "")
+	chdir(t, t.TempDir())
+	return h
+}
+
+func TestUserConfigPath(t *testing.T) {
+	h := setupDirs(t)
+
+	p, err := UserConfigPath()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := filepath.Join(h, ".config", "kid", "config.yaml"); p != e {
+		t.Errorf("expected path '%s', found '%s'", e, p)
+	}
+
+	x := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", x)
+	p, err = UserConfigPath()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := filepath.Join(x, "kid", "config.yaml"); p != e {
+		t.Errorf("expected path '%s' with XDG_CONFIG_HOME set, found '%s'", e, p)
+	}
+}
+
+func TestLoadUserConfig(t *testing.T) {
+	h := setupDirs(t)
+	x := t.TempDir()
+	writeFile(t, filepath.Join(h, ".config", "kid", "config.yaml"), "output: home\n")
+	writeFile(t, filepath.Join(x, "kid", "config.yaml"), "output: xdg\n")
+	e := filepath.Join(t.TempDir(), "kid.yaml")
+	writeFile(t, e, "output: env\n")
+
+	tt := []struct {
+		name     string
+		xdg      string
+		env      string
+		expected string
+	}{
+		{"home", "", "", "home"},
+		{"xdg", x, "", "xdg"},
+		{"env", x, e, "env"},
+	}
+	for _, tc := range tt {
+		t.Setenv("XDG_CONFIG_HOME", tc.xdg)
+		t.Setenv(EnvVar, tc.env)
+
+		c, err := Load()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if c.Output != tc.expected {
+			t.Errorf("%s: expected output '%s', found '%s'", tc.name, tc.expected, c.Output)
+		}
+	}
+}
+
+func TestLoadMissingFiles(t *testing.T) {
+	setupDirs(t)
+
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("expected a missing user configuration file to be ignored, found: %v", err)
+	}
+	if c.Output != "" || c.Contexts != nil || c.Identity.TokenTTL != nil {
+		t.Errorf("expected an empty configuration, found %+v", c)
+	}
+
+	t.Setenv(EnvVar, filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(); err == nil {
+		t.Errorf("expected an error for a missing file set with %s", EnvVar)
+	}
+}
+
+func TestLoadProjectOverridesUser(t *testing.T) {
+	h := setupDirs(t)
+	writeFile(t, filepath.Join(h, ".config", "kid", "config.yaml"), `
+contexts:
+  dev:
+    namespace: user-dev
+  prod:
+    namespace: user-prod
+identity:
+  labels:
+    team: payments
+    tier: backend
+  annotations:
+    owner: alice
+  tokenTTL: 1h
+output: json
+naming:
+  secretTemplate: "{{ .Name }}-token-{{ .Version }}"
+audit:
+  file: /var/log/kid.log
+`)
+
+	p := t.TempDir()
+	writeFile(t, filepath.Join(p, ProjectFileName), `
+contexts:
+  dev:
+    namespace: project-dev
+identity:
+  labels:
+    tier: frontend
+    app: web
+output: yaml
+`)
+	wd := filepath.Join(p, "a", "b")
+	if err := os.MkdirAll(wd, 0o700); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	chdir(t, wd)
+
+	if f, ok := FindProjectConfig(); !ok || f != filepath.Join(p, ProjectFileName) {
+		t.Fatalf("expected the project file to be found in a parent directory, found '%s'", f)
+	}
+
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := c.ContextNamespace("dev"); n != "project-dev" {
+		t.Errorf("expected the project namespace for context 'dev', found '%s'", n)
+	}
+	if n := c.ContextNamespace("prod"); n != "user-prod" {
+		t.Errorf("expected the user namespace for context 'prod', found '%s'", n)
+	}
+	if l := c.Identity.Labels; len(l) != 3 || l["team"] != "payments" || l["tier"] != "frontend" || l["app"] != "web" {
+		t.Errorf("expected the project labels merged on the user ones, found %v", l)
+	}
+	if a := c.Identity.Annotations; len(a) != 1 || a["owner"] != "alice" {
+		t.Errorf("expected the user annotations, found %v", a)
+	}
+	if ttl := c.Identity.TokenTTL; ttl == nil || ttl.Duration != time.Hour {
+		t.Errorf("expected the user token TTL when the project does not set it, found %v", ttl)
+	}
+	if c.Output != "yaml" {
+		t.Errorf("expected the project output, found '%s'", c.Output)
+	}
+	if c.Naming.SecretTemplate != "{{ .Name }}-token-{{ .Version }}" || c.Audit.File != "/var/log/kid.log" {
+		t.Errorf("expected the user naming and audit settings, found %+v and %+v", c.Naming, c.Audit)
+	}
+
+	writeFile(t, filepath.Join(p, ProjectFileName), "identity:\n  tokenTTL: 30m\n")
+	c, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := c.Identity.TokenTTL; ttl == nil || ttl.Duration != 30*time.Minute {
+		t.Errorf("expected the project token TTL, found %v", ttl)
+	}
+}
+
+func TestLoadFileInvalid(t *testing.T) {
+	tt := map[string]string{
+		"unknown key":       "output: json\nouptut: yaml\n",
+		"unknown sub key":   "identity:\n  label:\n    team: payments\n",
+		"invalid pattern":   "naming:\n  identityPattern: \"[a-z\"\n",
+		"invalid token TTL": "identity:\n  tokenTTL: soon\n",
+	}
+
+	for n, d := range tt {
+		p := filepath.Join(t.TempDir(), "config.yaml")
+		writeFile(t, p, d)
+
+		if _, err := LoadFile(p); err == nil || !strings.Contains(err.Error(), "invalid configuration file") {
+			t.Errorf("%s: expected the file to be rejected, found: %v", n, err)
+		}
+	}
+}
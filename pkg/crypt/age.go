@@ -0,0 +1,183 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+const armorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+var ErrInvalidRecipient = fmt.Errorf("invalid recipient")
+
+// ParseRecipients parses the given recipients.
+// Each one can be an age recipient, an SSH public key, or the path to a
+// file containing one of them per line.
+func ParseRecipients(rr []string) ([]age.Recipient, error) {
+	ar := []age.Recipient{}
+	for _, r := range rr {
+		if p, err := parseRecipient(r); err == nil {
+			ar = append(ar, p)
+			continue
+		}
+
+		f, err := os.ReadFile(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w '%s': it is neither an age recipient, an SSH public key nor a readable file", ErrInvalidRecipient, r)
+		}
+
+		fr, err := parseRecipientsFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing recipients file '%s': %w", r, err)
+		}
+		ar = append(ar, fr...)
+	}
+
+	return ar, nil
+}
+
+// ParseIdentities parses the given identity files.
+// Each file can be an age identity file or an SSH private key.
+// The passphrase function is invoked only for encrypted SSH keys.
+func ParseIdentities(pp []string, passphrase func() ([]byte, error)) ([]age.Identity, error) {
+	ii := []age.Identity{}
+	for _, p := range pp {
+		f, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := parseIdentitiesFile(f, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing identity file '%s': %w", p, err)
+		}
+		ii = append(ii, fi...)
+	}
+
+	return ii, nil
+}
+
+// Encrypt encrypts the data to the given recipients as an armored age file
+func Encrypt(data []byte, rr ...age.Recipient) ([]byte, error) {
+	b := bytes.Buffer{}
+	aw := armor.NewWriter(&b)
+
+	w, err := age.Encrypt(aw, rr...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	return append(b.Bytes(), '\n'), nil
+}
+
+// Decrypt decrypts an age file, armored or not, with the given identities
+func Decrypt(data []byte, ii ...age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armorHeader)) {
+		r = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+
+	dr, err := age.Decrypt(r, ii...)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(dr)
+}
+
+func parseRecipient(r string) (age.Recipient, error) {
+	if strings.HasPrefix(r, "age1") {
+		return age.ParseX25519Recipient(r)
+	}
+	return agessh.ParseRecipient(r)
+}
+
+func parseRecipientsFile(f []byte) ([]age.Recipient, error) {
+	rr := []age.Recipient{}
+	s := bufio.NewScanner(bytes.NewReader(f))
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		r, err := parseRecipient(l)
+		if err != nil {
+			return nil, fmt.Errorf("%w at line %d: %v", ErrInvalidRecipient, n, err)
+		}
+		rr = append(rr, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(rr) == 0 {
+		return nil, fmt.Errorf("%w: no recipient found", ErrInvalidRecipient)
+	}
+	return rr, nil
+}
+
+func parseIdentitiesFile(f []byte, passphrase func() ([]byte, error)) ([]age.Identity, error) {
+	if !bytes.Contains(f, []byte("PRIVATE KEY-----")) {
+		return age.ParseIdentities(bytes.NewReader(f))
+	}
+
+	i, err := agessh.ParseIdentity(f)
+	if err == nil {
+		return []age.Identity{i}, nil
+	}
+
+	var pme *ssh.PassphraseMissingError
+	if !errors.As(err, &pme) {
+		return nil, err
+	}
+	if pme.PublicKey == nil {
+		return nil, fmt.Errorf("encrypted SSH key without an embedded public key is not supported")
+	}
+
+	ei, err := agessh.NewEncryptedSSHIdentity(pme.PublicKey, f, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return []age.Identity{ei}, nil
+}
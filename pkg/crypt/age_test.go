@@ -0,0 +1,133 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package crypt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+const testData = "apiVersion: v1\nkind: Config\n"
+
+// writeTestFile writes the content to a file in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, content, 0o600); err != nil {
+		t.Fatalf("unexpected error writing '%s': %v", p, err)
+	}
+	return p
+}
+
+// newX25519Key returns an age recipient and the path of its identity file
+func newX25519Key(t *testing.T) (string, string) {
+	t.Helper()
+
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("unexpected error generating age identity: %v", err)
+	}
+	return i.Recipient().String(), writeTestFile(t, "key.txt", []byte(i.String()+"\n"))
+}
+
+// newSSHKey returns an SSH public key and the path of its private key file
+func newSSHKey(t *testing.T) (string, string) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating SSH key: %v", err)
+	}
+	sp, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("unexpected error building SSH public key: %v", err)
+	}
+	b, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("unexpected error marshalling SSH private key: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sp)), writeTestFile(t, "id_ed25519", pem.EncodeToMemory(b))
+}
+
+// assertRoundTrip encrypts to the recipients and decrypts with the identity file
+func assertRoundTrip(t *testing.T, recipients []string, identity string) {
+	t.Helper()
+
+	rr, err := ParseRecipients(recipients)
+	if err != nil {
+		t.Fatalf("unexpected error parsing recipients: %v", err)
+	}
+	e, err := Encrypt([]byte(testData), rr...)
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+
+	ii, err := ParseIdentities([]string{identity}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error parsing identities: %v", err)
+	}
+	d, err := Decrypt(e, ii...)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if string(d) != testData {
+		t.Errorf("expected decrypted data '%s', found '%s'", testData, d)
+	}
+}
+
+func TestRoundTripX25519(t *testing.T) {
+	r, i := newX25519Key(t)
+	assertRoundTrip(t, []string{r}, i)
+}
+
+func TestRoundTripSSH(t *testing.T) {
+	r, i := newSSHKey(t)
+	assertRoundTrip(t, []string{r}, i)
+}
+
+func TestRoundTripRecipientsFile(t *testing.T) {
+	ar, ai := newX25519Key(t)
+	sr, si := newSSHKey(t)
+	f := writeTestFile(t, "team, ops.txt", []byte("# age\n"+ar+"\n\n  # ssh\n"+sr+"\n\n"))
+
+	assertRoundTrip(t, []string{f}, ai)
+	assertRoundTrip(t, []string{f}, si)
+}
+
+func TestParseRecipientsInvalid(t *testing.T) {
+	f := writeTestFile(t, "recipients.txt", []byte("# only comments\n\n"))
+	for _, r := range []string{"age1invalid", filepath.Join(t.TempDir(), "missing.txt"), f} {
+		if _, err := ParseRecipients([]string{r}); !errors.Is(err, ErrInvalidRecipient) {
+			t.Errorf("expected error '%v' for '%s', found '%v'", ErrInvalidRecipient, r, err)
+		}
+	}
+}
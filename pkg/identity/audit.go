@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"errors"
+
+	"github.com/filariow/kid/pkg/audit"
+)
+
+// Auditor records the operations changing identities, e.g. in an audit log
+type Auditor interface {
+	Audit(r audit.Record) error
+}
+
+// WithAuditor sets the auditor recording the operations changing identities,
+// by default they are not recorded
+func WithAuditor(a Auditor) ManagerOption {
+	return func(m *Manager) {
+		m.auditor = a
+	}
+}
+
+// audit records the action performed on the identity, if an auditor is set.
+// opErr is the error the operation failed with, if any, and it is returned
+// joined with the error recording it. If the version is not known, it is
+// read from opErr.
+func (m *Manager) audit(action string, name string, namespace string, version *uint64, opErr error) error {
+	if m.auditor == nil {
+		return opErr
+	}
+
+	r := audit.Record{
+		Timestamp: m.now(),
+		Namespace: namespace,
+		Identity:  name,
+		Version:   version,
+		Action:    action,
+		Result:    audit.ResultSuccess,
+	}
+
+	var ie *Error
+	if opErr != nil {
+		r.Result = audit.ResultFailure
+		r.Reason = opErr.Error()
+		if r.Version == nil && errors.As(opErr, &ie) {
+			r.Version = ie.Version
+		}
+	}
+
+	if err := m.auditor.Audit(r); err != nil {
+		return errors.Join(opErr, err)
+	}
+	return opErr
+}
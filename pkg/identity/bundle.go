@@ -0,0 +1,153 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+type IdentityKubeconfig struct {
+	Identity   string
+	Kubeconfig *clientcmdapi.Config
+}
+
+// GetKubeconfigBundle builds the kubeconfigs for the given identities,
+// listing the namespace's token secrets only once.
+// The result preserves the order of the given names.
+func GetKubeconfigBundle(ctx context.Context, cli kubernetes.Interface, names []string, namespace string, opts GetKubeconfigOptions) ([]IdentityKubeconfig, error) {
+	return newDefaultManager(cli).GetKubeconfigBundle(ctx, names, namespace, opts)
+}
+
+// GetKubeconfigBundle builds the kubeconfigs for the given identities,
+// listing the namespace's token secrets only once.
+// The result preserves the order of the given names.
+func (m *Manager) GetKubeconfigBundle(ctx context.Context, names []string, namespace string, opts GetKubeconfigOptions) ([]IdentityKubeconfig, error) {
+	if opts.OverrideHost == nil {
+		cfg, err := kube.GetRESTConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.OverrideHost = &cfg.Host
+	}
+
+	gss, err := kube.GroupServiceAccountSecrets(ctx, m.cli, namespace)
+	if err != nil {
+		return nil, err
+	}
+	m.log.V(2).Info("listed token secrets", "namespace", namespace, "identities", len(gss))
+
+	kk := make([]IdentityKubeconfig, len(names))
+	ee := make([]error, len(names))
+	for i, n := range names {
+		k, err := m.getIdentityKubeconfig(n, namespace, gss[n], opts)
+		if err != nil {
+			ee[i] = fmt.Errorf("error building kubeconfig for identity '%s/%s': %w", namespace, n, err)
+			continue
+		}
+		kk[i] = IdentityKubeconfig{Identity: n, Kubeconfig: k}
+	}
+
+	if err := errors.Join(ee...); err != nil {
+		return nil, err
+	}
+	return kk, nil
+}
+
+// MergeKubeconfigBundle merges a bundle into a single kubeconfig with
+// one context and one user per identity, both named after the identity.
+func MergeKubeconfigBundle(kk []IdentityKubeconfig) (*clientcmdapi.Config, error) {
+	cc := make([]*clientcmdapi.Config, 0, len(kk))
+	for _, k := range kk {
+		c := k.Kubeconfig.DeepCopy()
+
+		ct := c.Contexts[c.CurrentContext]
+		ai := c.AuthInfos[ct.AuthInfo]
+		ct.AuthInfo = k.Identity
+		c.AuthInfos = map[string]*clientcmdapi.AuthInfo{k.Identity: ai}
+		c.Contexts = map[string]*clientcmdapi.Context{k.Identity: ct}
+		c.CurrentContext = k.Identity
+
+		cc = append(cc, c)
+	}
+
+	return MergeKubeconfigs(cc...)
+}
+
+// ListIdentities returns the names of the identities in the namespace
+// whose service accounts match the label selector, sorted by name.
+func ListIdentities(ctx context.Context, cli kubernetes.Interface, namespace string, selector string) ([]string, error) {
+	return newDefaultManager(cli).ListIdentitiesBySelector(ctx, namespace, selector)
+}
+
+// ListIdentitiesBySelector returns the names of the identities in the namespace
+// whose service accounts match the label selector, sorted by name.
+// Matching service accounts not managed by kid, having no token secret
+// named after the naming template, are left out.
+func (m *Manager) ListIdentitiesBySelector(ctx context.Context, namespace string, selector string) ([]string, error) {
+	ss, err := kube.ListServiceAccounts(ctx, m.cli, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	ii, err := m.ListIdentities(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	mm := make(map[string]struct{}, len(ii))
+	for _, i := range ii {
+		mm[i.Name] = struct{}{}
+	}
+
+	nn := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if _, ok := mm[s.Name]; ok {
+			nn = append(nn, s.Name)
+			continue
+		}
+		m.log.V(2).Info("skipping service account not managed by kid", "namespace", namespace, "serviceAccount", s.Name)
+	}
+	sort.Strings(nn)
+	return nn, nil
+}
+
+func (m *Manager) getIdentityKubeconfig(name string, namespace string, ss []corev1.Secret, opts GetKubeconfigOptions) (*clientcmdapi.Config, error) {
+	s, err := m.selectLastTokenSecret(name, namespace, ss)
+	if err != nil {
+		return nil, err
+	}
+
+	tkn, err := GetToken(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildKubeconfig(tkn, opts)
+}
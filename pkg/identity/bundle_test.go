@@ -0,0 +1,123 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestGetKubeconfigBundle(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 1)
+	createTestIdentity(t, cli, "db", 0)
+
+	m := NewManager(cli)
+	tt := map[string]string{}
+	for _, n := range []string{"app", "db"} {
+		s, err := m.getLastTokenSecret(context.TODO(), n, testNamespace)
+		if err != nil {
+			t.Fatalf("unexpected error getting the last token secret of '%s': %v", n, err)
+		}
+		tkn, err := GetToken(s)
+		if err != nil {
+			t.Fatalf("unexpected error getting the token of '%s': %v", n, err)
+		}
+		tt[n] = string(tkn.Token)
+	}
+
+	lists := 0
+	cli.PrependReactor("list", "secrets", func(action ktesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+
+	h := "https://kube.example.com"
+	kk, err := m.GetKubeconfigBundle(context.TODO(), []string{"db", "app"}, testNamespace, GetKubeconfigOptions{OverrideHost: &h})
+	if err != nil {
+		t.Fatalf("unexpected error building the bundle: %v", err)
+	}
+	if lists != 1 {
+		t.Errorf("expected secrets to be listed once, found %d lists", lists)
+	}
+	if len(kk) != 2 || kk[0].Identity != "db" || kk[1].Identity != "app" {
+		t.Fatalf("expected kubeconfigs for 'db' and 'app' in order, found %+v", kk)
+	}
+	for _, k := range kk {
+		c := k.Kubeconfig
+		if s := c.Clusters[defaultKubeconfigCluster].Server; s != h {
+			t.Errorf("expected server '%s' for '%s', found '%s'", h, k.Identity, s)
+		}
+		if tkn := c.AuthInfos[defaultKubeconfigUser].Token; tkn != tt[k.Identity] {
+			t.Errorf("expected the last token of '%s' in its kubeconfig", k.Identity)
+		}
+	}
+}
+
+func TestGetKubeconfigBundleMissingIdentity(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 0)
+
+	h := "https://kube.example.com"
+	_, err := GetKubeconfigBundle(context.TODO(), cli, []string{"app", "missing"}, testNamespace, GetKubeconfigOptions{OverrideHost: &h})
+	if !errors.Is(err, kube.ErrSecretNotFound) {
+		t.Fatalf("expected error '%v', found '%v'", kube.ErrSecretNotFound, err)
+	}
+}
+
+func TestListIdentitiesBySelectorSkipsUnmanaged(t *testing.T) {
+	cli := newFakeClient(&corev1.ServiceAccount{
+		ObjectMeta: mv1.ObjectMeta{Name: "web", Namespace: testNamespace, Labels: map[string]string{"team": "payments"}},
+	})
+	createTestIdentity(t, cli, "app", 0)
+	createTestIdentity(t, cli, "db", 0)
+
+	sa, err := cli.CoreV1().ServiceAccounts(testNamespace).Get(context.TODO(), "app", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error getting service account: %v", err)
+	}
+	sa.Labels = map[string]string{"team": "payments"}
+	if _, err := cli.CoreV1().ServiceAccounts(testNamespace).Update(context.TODO(), sa, mv1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error labelling service account: %v", err)
+	}
+
+	nn, err := ListIdentities(context.TODO(), cli, testNamespace, "team=payments")
+	if err != nil {
+		t.Fatalf("unexpected error listing identities: %v", err)
+	}
+	if len(nn) != 1 || nn[0] != "app" {
+		t.Fatalf("expected only identity 'app', found %v", nn)
+	}
+
+	h := "https://kube.example.com"
+	if _, err := GetKubeconfigBundle(context.TODO(), cli, nn, testNamespace, GetKubeconfigOptions{OverrideHost: &h}); err != nil {
+		t.Errorf("unexpected error building the bundle of the selected identities: %v", err)
+	}
+}
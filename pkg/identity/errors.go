@@ -0,0 +1,127 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/filariow/kid/pkg/kube"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	// ErrNotFound is returned when the identity or the token version does not exist
+	ErrNotFound = fmt.Errorf("not found")
+	// ErrAlreadyExists is returned when creating an identity that already exists
+	ErrAlreadyExists = fmt.Errorf("already exists")
+	// ErrSecretMalformed is returned when the secret storing a token is not the one expected
+	ErrSecretMalformed = fmt.Errorf("service account's secret malformed")
+	// ErrVersionConflict is returned when a token version can not be created,
+	// as it already exists or it is not lower than the last one
+	ErrVersionConflict = fmt.Errorf("token version conflict")
+
+	// ErrIdentityNotFound is returned when the identity has no service account or
+	// no token secrets, it matches ErrNotFound
+	ErrIdentityNotFound = fmt.Errorf("identity %w", ErrNotFound)
+	// ErrIdentityExists is returned when creating an identity that already exists,
+	// it matches ErrAlreadyExists
+	ErrIdentityExists = fmt.Errorf("identity %w", ErrAlreadyExists)
+	// ErrVersionNotFound is returned when the token version does not exist,
+	// it matches ErrNotFound
+	ErrVersionNotFound = fmt.Errorf("token version %w", ErrNotFound)
+	// ErrRotationInProgress is returned when beginning a rotation before
+	// the previous one has been completed
+	ErrRotationInProgress = fmt.Errorf("token rotation in progress")
+)
+
+// Error is returned when an operation on an identity fails for one of the
+// package's sentinel errors, its Reason. It matches with errors.Is both the
+// reason and the cause, so that errors returned by the API server can still be
+// checked with the functions of k8s.io/apimachinery/pkg/api/errors.
+type Error struct {
+	// Reason is the sentinel error, like ErrIdentityExists
+	Reason    error
+	Identity  string
+	Namespace string
+	// Version is the token version the operation failed for, if any
+	Version *uint64
+	// Cause is the error that caused the failure, if any
+	Cause error
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("%v: '%s/%s'", e.Reason, e.Namespace, e.Identity)
+	if e.Version != nil {
+		s += fmt.Sprintf(" version %d", *e.Version)
+	}
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+	return s
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Reason}
+	}
+	return []error{e.Reason, e.Cause}
+}
+
+func newIdentityError(reason error, name string, namespace string, cause error) *Error {
+	return &Error{Reason: reason, Identity: name, Namespace: namespace, Cause: cause}
+}
+
+func newVersionError(reason error, name string, namespace string, version uint64, cause error) *Error {
+	return &Error{Reason: reason, Identity: name, Namespace: namespace, Version: &version, Cause: cause}
+}
+
+// wrapIdentityError makes the errors returned by the API server for the
+// identity's service account or secrets match the package's sentinel errors
+func wrapIdentityError(err error, name string, namespace string) error {
+	switch {
+	case err == nil:
+		return nil
+	case kerrors.IsNotFound(err), errors.Is(err, kube.ErrSecretNotFound):
+		return newIdentityError(ErrIdentityNotFound, name, namespace, err)
+	case kerrors.IsAlreadyExists(err):
+		return newIdentityError(ErrIdentityExists, name, namespace, err)
+	default:
+		return err
+	}
+}
+
+// wrapVersionError makes the errors returned by the API server for the
+// secret storing a token version match the package's sentinel errors
+func wrapVersionError(err error, name string, namespace string, version uint64) error {
+	switch {
+	case err == nil:
+		return nil
+	case kerrors.IsNotFound(err):
+		return newVersionError(ErrVersionNotFound, name, namespace, version, err)
+	case kerrors.IsAlreadyExists(err):
+		return newVersionError(ErrVersionConflict, name, namespace, version, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the events recorded on the identities' service accounts
+const (
+	EventReasonIdentityCreated        = "IdentityCreated"
+	EventReasonTokenVersionCreated    = "TokenVersionCreated"
+	EventReasonTokenRotationBegun     = "TokenRotationBegun"
+	EventReasonTokenRotationCompleted = "TokenRotationCompleted"
+	EventReasonTokenRevoked           = "TokenRevoked"
+	EventReasonTokenRolledBack        = "TokenRolledBack"
+)
+
+const (
+	eventComponent           = "kid"
+	eventReportingController = "kid.filariow.github.io/kid"
+)
+
+// recordEvent records an event on the identity's service account, so that
+// the history of its tokens is shown by 'kubectl describe serviceaccount'.
+// Events are informative: failing to record them does not fail the operation.
+func (m *Manager) recordEvent(ctx context.Context, sa *corev1.ServiceAccount, reason string, message string) {
+	if o := m.getOperator(ctx); o != "" {
+		message = fmt.Sprintf("%s by %s", message, o)
+	}
+
+	t := mv1.NewTime(m.now())
+	e := &corev1.Event{
+		ObjectMeta: mv1.ObjectMeta{
+			// named as client-go's event recorder does, with the actual time
+			// so that names are unique even if the clock is fixed
+			Name:      fmt.Sprintf("%s.%x", sa.Name, time.Now().UnixNano()),
+			Namespace: sa.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "ServiceAccount",
+			Namespace:       sa.Namespace,
+			Name:            sa.Name,
+			UID:             sa.UID,
+			ResourceVersion: sa.ResourceVersion,
+		},
+		Reason:              reason,
+		Message:             message,
+		Type:                corev1.EventTypeNormal,
+		Source:              corev1.EventSource{Component: eventComponent},
+		FirstTimestamp:      t,
+		LastTimestamp:       t,
+		Count:               1,
+		ReportingController: eventReportingController,
+	}
+
+	if _, err := m.cli.CoreV1().Events(sa.Namespace).Create(ctx, e, mv1.CreateOptions{}); err != nil {
+		m.log.Error(err, "can not record event", "namespace", sa.Namespace, "identity", sa.Name, "reason", reason)
+		return
+	}
+	m.log.V(1).Info("recorded event", "namespace", sa.Namespace, "identity", sa.Name, "reason", reason, "message", message)
+}
+
+// recordIdentityEvent records an event on the service account of the identity with the given name
+func (m *Manager) recordIdentityEvent(ctx context.Context, name string, namespace string, reason string, message string) {
+	sa, err := m.cli.CoreV1().ServiceAccounts(namespace).Get(ctx, name, mv1.GetOptions{})
+	if err != nil {
+		m.log.Error(err, "can not record event", "namespace", namespace, "identity", name, "reason", reason)
+		return
+	}
+	m.recordEvent(ctx, sa, reason, message)
+}
+
+// getOperator returns the user performing the operations, the one set with
+// WithOperator or the one the Manager's client is authenticated as, as told
+// by the cluster. The latter is resolved only once, even if it can not be.
+func (m *Manager) getOperator(ctx context.Context) string {
+	m.operatorOnce.Do(func() {
+		if m.operator == "" {
+			m.operator = kube.GetUsername(ctx, m.cli)
+		}
+	})
+	return m.operator
+}
@@ -0,0 +1,317 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filariow/kid/pkg/sealedsecret"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	FormatKubeconfig           string = "kubeconfig"
+	FormatGitHubActions        string = "github-actions"
+	FormatGitLabCI             string = "gitlab-ci"
+	FormatArgoCDCluster        string = "argocd-cluster"
+	FormatFluxKubeconfigSecret string = "flux-kubeconfig-secret"
+	FormatSealedSecret         string = "sealed-secret"
+
+	SealedContentKubeconfig string = "kubeconfig"
+	SealedContentToken      string = "token"
+)
+
+var ExportFormats = []string{
+	FormatKubeconfig,
+	FormatGitHubActions,
+	FormatGitLabCI,
+	FormatArgoCDCluster,
+	FormatFluxKubeconfigSecret,
+	FormatSealedSecret,
+}
+
+var ErrUnknownFormat = fmt.Errorf("unknown export format")
+
+// Credentials holds everything needed to authenticate as an identity
+type Credentials struct {
+	Identity   string
+	Namespace  string
+	Version    uint64
+	Server     string
+	Token      *ServiceAccountToken
+	Kubeconfig []byte
+}
+
+type RenderOptions struct {
+	SecretName      *string
+	SecretNamespace *string
+	ClusterName     *string
+
+	// SealingKey is the public key of the sealed-secrets controller
+	SealingKey *rsa.PublicKey
+	// SealingScope is one of strict, namespace-wide and cluster-wide
+	SealingScope string
+	// SealedContent is the content of the SealedSecret, kubeconfig or token
+	SealedContent string
+}
+
+// GetCredentials fetches the last token of the identity and builds its kubeconfig
+func GetCredentials(ctx context.Context, cli kubernetes.Interface, name string, namespace string, opts GetKubeconfigOptions) (*Credentials, error) {
+	return newDefaultManager(cli).GetCredentials(ctx, name, namespace, opts)
+}
+
+// GetCredentials fetches the last token of the identity and builds its kubeconfig
+func (m *Manager) GetCredentials(ctx context.Context, name string, namespace string, opts GetKubeconfigOptions) (*Credentials, error) {
+	s, err := m.getLastTokenSecret(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tkn, err := GetToken(s)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := m.tokenVersion(s)
+	if err != nil {
+		return nil, err
+	}
+
+	cc, err := BuildKubeconfig(tkn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kfg, err := clientcmd.Write(*cc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		Identity:   name,
+		Namespace:  namespace,
+		Version:    v,
+		Server:     cc.Clusters[cc.Contexts[cc.CurrentContext].Cluster].Server,
+		Token:      tkn,
+		Kubeconfig: kfg,
+	}, nil
+}
+
+// RenderCredentials renders the credentials in the given format
+func RenderCredentials(c *Credentials, format string, opts RenderOptions) ([]byte, error) {
+	switch format {
+	case FormatKubeconfig:
+		return c.Kubeconfig, nil
+	case FormatGitHubActions:
+		return renderGitHubActions(c), nil
+	case FormatGitLabCI:
+		return renderGitLabCI(c)
+	case FormatArgoCDCluster:
+		return renderArgoCDCluster(c, opts)
+	case FormatFluxKubeconfigSecret:
+		return renderFluxKubeconfigSecret(c, opts)
+	case FormatSealedSecret:
+		return renderSealedSecret(c, opts)
+	default:
+		return nil, fmt.Errorf("%w '%s', valid formats are: %s", ErrUnknownFormat, format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// renderGitHubActions renders a dotenv file to be used with 'gh secret set -f'.
+// Multi-line values are base64 encoded.
+func renderGitHubActions(c *Credentials) []byte {
+	vv := map[string]string{
+		"KID_IDENTITY":          c.Identity,
+		"KID_TOKEN_VERSION":     strconv.FormatUint(c.Version, 10),
+		"KID_NAMESPACE":         c.Namespace,
+		"KID_SERVER":            c.Server,
+		"KID_TOKEN":             string(c.Token.Token),
+		"KID_CA_CRT_BASE64":     base64.StdEncoding.EncodeToString(c.Token.CACrt),
+		"KID_KUBECONFIG_BASE64": base64.StdEncoding.EncodeToString(c.Kubeconfig),
+	}
+
+	kk := make([]string, 0, len(vv))
+	for k := range vv {
+		kk = append(kk, k)
+	}
+	sort.Strings(kk)
+
+	b := strings.Builder{}
+	for _, k := range kk {
+		fmt.Fprintf(&b, "%s=%s\n", k, strconv.Quote(vv[k]))
+	}
+	return []byte(b.String())
+}
+
+type gitLabVariable struct {
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+	VariableType string `json:"variable_type"`
+	Masked       bool   `json:"masked"`
+	Protected    bool   `json:"protected"`
+	Raw          bool   `json:"raw"`
+}
+
+// renderGitLabCI renders the variables in the format accepted by GitLab's
+// project and group variables API.
+// Kubeconfig and CA certificate are rendered as file variables.
+func renderGitLabCI(c *Credentials) ([]byte, error) {
+	vv := []gitLabVariable{
+		{Key: "KID_IDENTITY", Value: c.Identity, VariableType: "env_var"},
+		{Key: "KID_TOKEN_VERSION", Value: strconv.FormatUint(c.Version, 10), VariableType: "env_var"},
+		{Key: "KID_NAMESPACE", Value: c.Namespace, VariableType: "env_var"},
+		{Key: "KID_SERVER", Value: c.Server, VariableType: "env_var"},
+		{Key: "KID_TOKEN", Value: string(c.Token.Token), VariableType: "env_var", Masked: true},
+		{Key: "KID_CA_CRT", Value: string(c.Token.CACrt), VariableType: "file"},
+		{Key: "KUBECONFIG", Value: string(c.Kubeconfig), VariableType: "file"},
+	}
+	for i := range vv {
+		vv[i].Raw = true
+	}
+
+	j, err := json.MarshalIndent(vv, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(j, '\n'), nil
+}
+
+type argoCDClusterConfig struct {
+	BearerToken     string                 `json:"bearerToken"`
+	TLSClientConfig argoCDClusterTLSConfig `json:"tlsClientConfig"`
+}
+
+type argoCDClusterTLSConfig struct {
+	Insecure bool   `json:"insecure"`
+	CAData   string `json:"caData"`
+}
+
+// renderArgoCDCluster renders an Argo CD declarative cluster Secret
+func renderArgoCDCluster(c *Credentials, opts RenderOptions) ([]byte, error) {
+	cfg, err := json.Marshal(argoCDClusterConfig{
+		BearerToken: string(c.Token.Token),
+		TLSClientConfig: argoCDClusterTLSConfig{
+			CAData: base64.StdEncoding.EncodeToString(c.Token.CACrt),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSecretManifest(
+		valueOrDefault(opts.SecretName, fmt.Sprintf("cluster-%s", c.Identity)),
+		valueOrDefault(opts.SecretNamespace, "argocd"),
+		map[string]string{
+			"name":   valueOrDefault(opts.ClusterName, c.Identity),
+			"server": c.Server,
+			"config": string(cfg),
+		})
+	s.Labels = map[string]string{"argocd.argoproj.io/secret-type": "cluster"}
+
+	return yaml.Marshal(s)
+}
+
+// renderFluxKubeconfigSecret renders a Secret holding the kubeconfig in the
+// 'value' key, as expected by Flux's kubeConfig.secretRef
+func renderFluxKubeconfigSecret(c *Credentials, opts RenderOptions) ([]byte, error) {
+	s := newSecretManifest(
+		valueOrDefault(opts.SecretName, fmt.Sprintf("%s-kubeconfig", c.Identity)),
+		valueOrDefault(opts.SecretNamespace, "flux-system"),
+		map[string]string{"value": string(c.Kubeconfig)})
+
+	return yaml.Marshal(s)
+}
+
+// renderSealedSecret renders a SealedSecret holding either the kubeconfig in
+// the 'kubeconfig' key, or token, CA and namespace as in service account token Secrets.
+// Data is encrypted offline with the controller's public key.
+func renderSealedSecret(c *Credentials, opts RenderOptions) ([]byte, error) {
+	if opts.SealingKey == nil {
+		return nil, fmt.Errorf("format '%s' requires the public key of the sealed-secrets controller", FormatSealedSecret)
+	}
+
+	sc := opts.SealedContent
+	if sc == "" {
+		sc = SealedContentKubeconfig
+	}
+
+	var d map[string][]byte
+	switch sc {
+	case SealedContentKubeconfig:
+		d = map[string][]byte{"kubeconfig": c.Kubeconfig}
+	case SealedContentToken:
+		d = map[string][]byte{
+			corev1.ServiceAccountTokenKey:     c.Token.Token,
+			corev1.ServiceAccountRootCAKey:    c.Token.CACrt,
+			corev1.ServiceAccountNamespaceKey: c.Token.Namespace,
+		}
+	default:
+		return nil, fmt.Errorf("invalid sealed content '%s', valid values are: %s, %s", sc, SealedContentKubeconfig, SealedContentToken)
+	}
+
+	s := newSecretManifest(
+		valueOrDefault(opts.SecretName, fmt.Sprintf("%s-%s", c.Identity, sc)),
+		valueOrDefault(opts.SecretNamespace, c.Namespace),
+		nil)
+	s.Data = d
+
+	ss, err := sealedsecret.Seal(opts.SealingKey, opts.SealingScope, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(ss)
+}
+
+func newSecretManifest(name string, namespace string, data map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: data,
+	}
+}
+
+func valueOrDefault(v *string, d string) string {
+	if v != nil {
+		return *v
+	}
+	return d
+}
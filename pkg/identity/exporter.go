@@ -0,0 +1,189 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	StdoutScheme = "stdout"
+	FileScheme   = "file"
+)
+
+var ErrUnknownExporter = fmt.Errorf("unknown exporter")
+
+// Exporter writes the credentials of an identity to a destination
+type Exporter interface {
+	Export(ctx context.Context, c *Credentials) error
+}
+
+// ExporterFactory builds an Exporter for the given target URL
+type ExporterFactory func(ctx context.Context, target *url.URL, opts ExporterOptions) (Exporter, error)
+
+type ExporterOptions struct {
+	// Format used by exporters writing rendered credentials, defaults to kubeconfig.
+	// Exporters not rendering the credentials fail if it is set.
+	Format string
+	Render RenderOptions
+	// Encode, if set, is applied to rendered credentials before writing them
+	Encode func(data []byte) ([]byte, error)
+}
+
+var (
+	exportersMu sync.RWMutex
+	exporters   = map[string]ExporterFactory{}
+)
+
+func init() {
+	RegisterExporter(StdoutScheme, newStdoutExporter)
+	RegisterExporter(FileScheme, newFileExporter)
+}
+
+// RegisterExporter makes an exporter available for the given URL scheme.
+// It panics if an exporter is already registered for the scheme.
+func RegisterExporter(scheme string, f ExporterFactory) {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+
+	if f == nil {
+		panic("identity: RegisterExporter factory is nil")
+	}
+	if _, ok := exporters[scheme]; ok {
+		panic("identity: RegisterExporter called twice for scheme " + scheme)
+	}
+	exporters[scheme] = f
+}
+
+// Exporters returns the sorted list of registered schemes
+func Exporters() []string {
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+
+	ss := make([]string, 0, len(exporters))
+	for s := range exporters {
+		ss = append(ss, s)
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+// NewExporter builds the exporter registered for the scheme of the target,
+// e.g. 'file://./kubeconfig' or 'vault://secret/ci'
+func NewExporter(ctx context.Context, target string, opts ExporterOptions) (Exporter, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	exportersMu.RLock()
+	f, ok := exporters[u.Scheme]
+	exportersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w '%s', registered exporters are: %s", ErrUnknownExporter, u.Scheme, strings.Join(Exporters(), ", "))
+	}
+
+	return f(ctx, u, opts)
+}
+
+// writerExporter renders the credentials and writes them
+type writerExporter struct {
+	format string
+	render RenderOptions
+	encode func(data []byte) ([]byte, error)
+	open   func() (io.WriteCloser, error)
+}
+
+func (e *writerExporter) Export(_ context.Context, c *Credentials) error {
+	d, err := RenderCredentials(c, e.format, e.render)
+	if err != nil {
+		return err
+	}
+
+	if e.encode != nil {
+		if d, err = e.encode(d); err != nil {
+			return err
+		}
+	}
+
+	w, err := e.open()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(d); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func newWriterExporter(opts ExporterOptions, open func() (io.WriteCloser, error)) *writerExporter {
+	f := opts.Format
+	if f == "" {
+		f = FormatKubeconfig
+	}
+
+	return &writerExporter{
+		format: f,
+		render: opts.Render,
+		encode: opts.Encode,
+		open:   open,
+	}
+}
+
+// newStdoutExporter builds an exporter for 'stdout://'
+func newStdoutExporter(_ context.Context, _ *url.URL, opts ExporterOptions) (Exporter, error) {
+	return newWriterExporter(opts, func() (io.WriteCloser, error) {
+		return nopCloser{os.Stdout}, nil
+	}), nil
+}
+
+// newFileExporter builds an exporter for 'file://<path>'.
+// Relative paths are supported in the form 'file://./<path>'.
+func newFileExporter(_ context.Context, target *url.URL, opts ExporterOptions) (Exporter, error) {
+	p := filepath.FromSlash(target.Host + target.Path)
+	if p == "" {
+		return nil, fmt.Errorf("invalid file target '%s': expected format is 'file://<path>'", target)
+	}
+
+	return newWriterExporter(opts, func() (io.WriteCloser, error) {
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			return nil, err
+		}
+		return os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	}), nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
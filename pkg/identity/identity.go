@@ -24,12 +24,13 @@ package identity
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"time"
 
 	"github.com/filariow/kid/pkg/kube"
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -40,22 +41,12 @@ type Instance struct {
 	Secrets        []string `json:"secrets"`
 }
 
-func CreateIdentity(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) (*Instance, error) {
-	ss, err := kube.GetServiceAccountSecrets(ctx, cli, name, namespace)
-	if err != nil {
-		return nil, err
-	}
-	if len(ss) > 0 {
-		return nil, fmt.Errorf("error access tokens for Service Account '%s/%s' already exists", namespace, name)
-	}
-
-	sa, err := kube.CreateServiceAccount(ctx, cli, name, namespace)
-	if err != nil {
-		return nil, err
-	}
+// The following functions use the default options: secrets are named after
+// DefaultSecretNameTemplate and no label, annotation or TTL is set.
+// Use a Manager to configure them.
 
-	sn := createSecretName(name, 1)
-	s, err := kube.CreateServiceAccountSecret(ctx, cli, sn, namespace, sa)
+func CreateIdentity(ctx context.Context, cli kubernetes.Interface, name string, namespace string) (*Instance, error) {
+	sa, s, err := newDefaultManager(cli).createIdentity(ctx, name, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -67,119 +58,296 @@ func CreateIdentity(ctx context.Context, cli kubernetes.Clientset, name string,
 	}, nil
 }
 
-func CreateNewTokenVersion(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) (*corev1.Secret, error) {
-	sa, err := cli.CoreV1().ServiceAccounts(namespace).Get(ctx, name, mv1.GetOptions{})
+func CreateNewTokenVersion(ctx context.Context, cli kubernetes.Interface, name string, namespace string) (*corev1.Secret, error) {
+	return newDefaultManager(cli).createNewTokenVersion(ctx, name, namespace)
+}
+
+// BeginIdentityKeyRotation creates a new token version. It fails with
+// ErrRotationInProgress if the previous rotation has not been completed.
+func BeginIdentityKeyRotation(ctx context.Context, cli kubernetes.Interface, name string, namespace string) (*corev1.Secret, error) {
+	return newDefaultManager(cli).beginRotation(ctx, name, namespace)
+}
+
+func RollbackIdentityKey(ctx context.Context, cli kubernetes.Interface, name string, namespace string, version uint64) (*corev1.Secret, error) {
+	return newDefaultManager(cli).rollbackTokenVersion(ctx, name, namespace, version)
+}
+
+func RevokeIdentityKey(ctx context.Context, cli kubernetes.Interface, name string, namespace string, version uint64) (*string, error) {
+	sn, err := newDefaultManager(cli).revokeTokenVersion(ctx, name, namespace, version)
 	if err != nil {
 		return nil, err
 	}
+	return &sn, nil
+}
 
-	s, err := kube.GetLastServiceAccountSecrets(ctx, cli, name, namespace)
+func CompleteIdentityKeyRotation(ctx context.Context, cli kubernetes.Interface, name string, namespace string) (*string, error) {
+	sn, _, err := newDefaultManager(cli).completeRotation(ctx, name, namespace)
 	if err != nil {
 		return nil, err
 	}
+	return &sn, nil
+}
+
+// GetLastTokenSecret returns the secret storing the identity's last token,
+// that is the most recently created one
+func GetLastTokenSecret(ctx context.Context, cli kubernetes.Interface, name string, namespace string) (*corev1.Secret, error) {
+	return newDefaultManager(cli).getLastTokenSecret(ctx, name, namespace)
+}
+
+// GetTokenVersion returns the version of the token stored in the given secret
+func GetTokenVersion(secret *corev1.Secret) (uint64, error) {
+	return newDefaultManager(nil).tokenVersion(secret)
+}
 
-	sn, err := nextSecretAccountSecretName(s.Name)
+// ParseTokenVersion returns the version of the identity's token from the
+// name of the secret storing it
+func ParseTokenVersion(identity string, secretName string) (uint64, error) {
+	return defaultSecretNamer.ParseVersion(identity, secretName)
+}
+
+func (m *Manager) createIdentity(ctx context.Context, name string, namespace string) (*corev1.ServiceAccount, *corev1.Secret, error) {
+	ss, err := m.getTokenSecrets(ctx, name, namespace)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if len(ss) > 0 {
+		return nil, nil, newIdentityError(ErrIdentityExists, name, namespace, nil)
 	}
 
-	return kube.CreateServiceAccountSecret(ctx, cli, *sn, namespace, sa)
-}
+	if _, err := m.namer().Name(name, 1); err != nil {
+		return nil, nil, err
+	}
+
+	sa, err := kube.CreateServiceAccount(ctx, m.cli, name, namespace, m.opts.Labels, m.opts.Annotations)
+	if err != nil {
+		return nil, nil, wrapIdentityError(err, name, namespace)
+	}
+	m.log.V(1).Info("created service account", "namespace", namespace, "identity", name)
 
-func BeginIdentityKeyRotation(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) (*corev1.Secret, error) {
-	return CreateNewTokenVersion(ctx, cli, name, namespace)
+	s, err := m.createTokenSecret(ctx, sa, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.recordEvent(ctx, sa, EventReasonIdentityCreated, fmt.Sprintf("Identity created with token version 1 in secret %s", s.Name))
+	return sa, s, nil
 }
 
-func RollbackIdentityKey(ctx context.Context, cli kubernetes.Clientset, name string, namespace string, version uint64) (*corev1.Secret, error) {
-	sa, err := cli.CoreV1().ServiceAccounts(namespace).Get(ctx, name, mv1.GetOptions{})
+func (m *Manager) createNewTokenVersion(ctx context.Context, name string, namespace string) (*corev1.Secret, error) {
+	sa, v, err := m.getLastTokenVersion(ctx, name, namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	ls, err := kube.GetLastServiceAccountSecrets(ctx, cli, name, namespace)
+	s, err := m.createTokenSecret(ctx, sa, v+1)
 	if err != nil {
 		return nil, err
 	}
+	m.recordEvent(ctx, sa, EventReasonTokenVersionCreated, fmt.Sprintf("Token version %d created in secret %s", v+1, s.Name))
+	return s, nil
+}
 
-	_, p, err := splitServiceAccountSecretName(ls.GetName())
+// beginRotation creates a new token version, if the version preceding the
+// last one has been deleted, that is if the previous rotation is completed
+func (m *Manager) beginRotation(ctx context.Context, name string, namespace string) (*corev1.Secret, error) {
+	sa, v, err := m.getLastTokenVersion(ctx, name, namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	if version >= p {
-		return nil, fmt.Errorf("provided version is higher than latest token version '%d'", p)
+	if v > 0 {
+		sn, err := m.namer().Name(name, v-1)
+		if err != nil {
+			return nil, err
+		}
+		_, err = m.cli.CoreV1().Secrets(namespace).Get(ctx, sn, mv1.GetOptions{})
+		if err == nil {
+			return nil, newVersionError(ErrRotationInProgress, name, namespace, v-1, nil)
+		}
+		if !kerrors.IsNotFound(err) {
+			return nil, err
+		}
 	}
 
-	sn := createSecretName(name, version)
-	return kube.CreateServiceAccountSecret(ctx, cli, sn, namespace, sa)
-}
-
-func RevokeIdentityKey(ctx context.Context, cli kubernetes.Clientset, name string, namespace string, version uint64) (*string, error) {
-	sn := createSecretName(name, version)
-	if err := kube.DeleteServiceAccountSecret(ctx, cli, sn, namespace); err != nil {
+	s, err := m.createTokenSecret(ctx, sa, v+1)
+	if err != nil {
 		return nil, err
 	}
-	return &sn, nil
+	m.recordEvent(ctx, sa, EventReasonTokenRotationBegun, fmt.Sprintf("Token rotation begun, token version %d created in secret %s", v+1, s.Name))
+	return s, nil
 }
 
-func CompleteIdentityKeyRotation(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) (*string, error) {
-	s, err := kube.GetLastServiceAccountSecrets(ctx, cli, name, namespace)
+func (m *Manager) rollbackTokenVersion(ctx context.Context, name string, namespace string, version uint64) (*corev1.Secret, error) {
+	if version == 0 {
+		return nil, newVersionError(ErrVersionNotFound, name, namespace, version, errors.New("token versions start at 1"))
+	}
+
+	sa, p, err := m.getLastTokenVersion(ctx, name, namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	sn, err := previousSecretAccountSecretName(s.Name)
+	if version >= p {
+		return nil, newVersionError(ErrVersionConflict, name, namespace, version, fmt.Errorf("provided version is not lower than latest token version '%d'", p))
+	}
+
+	s, err := m.createTokenSecret(ctx, sa, version)
 	if err != nil {
 		return nil, err
 	}
+	m.recordEvent(ctx, sa, EventReasonTokenRolledBack, fmt.Sprintf("Token version %d rolled back in secret %s", version, s.Name))
+	return s, nil
+}
 
-	return sn, cli.CoreV1().Secrets(namespace).Delete(ctx, *sn, mv1.DeleteOptions{})
+func (m *Manager) revokeTokenVersion(ctx context.Context, name string, namespace string, version uint64) (string, error) {
+	sn, err := m.deleteTokenVersion(ctx, name, namespace, version)
+	if err != nil {
+		return "", err
+	}
+	m.recordIdentityEvent(ctx, name, namespace, EventReasonTokenRevoked, fmt.Sprintf("Token version %d revoked, secret %s deleted", version, sn))
+	return sn, nil
 }
 
-func createSecretName(sa string, version uint64) string {
-	return fmt.Sprintf("%s-key-%d", sa, version)
+func (m *Manager) deleteTokenVersion(ctx context.Context, name string, namespace string, version uint64) (string, error) {
+	sn, err := m.namer().Name(name, version)
+	if err != nil {
+		return "", err
+	}
+	if err := kube.DeleteServiceAccountSecret(ctx, m.cli, sn, namespace); err != nil {
+		return "", wrapVersionError(err, name, namespace, version)
+	}
+	m.log.V(1).Info("deleted token secret", "namespace", namespace, "identity", name, "version", version, "secret", sn)
+	return sn, nil
 }
 
-func splitServiceAccountSecretName(name string) (string, uint64, error) {
-	ss := strings.Split(name, "-")
-	if len(ss) <= 1 {
-		return "", 0, fmt.Errorf("invalid name for Service Account name: %s", name)
+// completeRotation deletes the token version preceding the last one and
+// returns the name of the deleted secret and its version
+func (m *Manager) completeRotation(ctx context.Context, name string, namespace string) (string, uint64, error) {
+	s, err := m.getLastTokenSecret(ctx, name, namespace)
+	if err != nil {
+		return "", 0, err
 	}
 
-	p := ss[len(ss)-1]
-	u, err := strconv.ParseUint(p, 10, 64)
+	v, err := m.tokenVersion(s)
 	if err != nil {
 		return "", 0, err
 	}
+	if v == 0 {
+		return "", 0, newVersionError(ErrVersionNotFound, name, namespace, v, fmt.Errorf("no prior secret to %s", s.Name))
+	}
 
-	b := strings.Join(ss[:len(ss)-1], "-")
-	return b, u, nil
+	sn, err := m.deleteTokenVersion(ctx, name, namespace, v-1)
+	if err != nil {
+		return "", 0, err
+	}
+	m.recordIdentityEvent(ctx, name, namespace, EventReasonTokenRotationCompleted, fmt.Sprintf("Token rotation completed, token version %d revoked, secret %s deleted", v-1, sn))
+	return sn, v - 1, nil
 }
 
-func nextSecretAccountSecretName(name string) (*string, error) {
-	b, p, err := splitServiceAccountSecretName(name)
+// getLastTokenVersion returns the identity's service account and the version
+// of its last token
+func (m *Manager) getLastTokenVersion(ctx context.Context, name string, namespace string) (*corev1.ServiceAccount, uint64, error) {
+	sa, err := m.cli.CoreV1().ServiceAccounts(namespace).Get(ctx, name, mv1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, 0, wrapIdentityError(err, name, namespace)
 	}
 
-	p++
-	n := fmt.Sprintf("%s-%d", b, p)
+	s, err := m.getLastTokenSecret(ctx, name, namespace)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	return &n, nil
+	v, err := m.tokenVersion(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sa, v, nil
 }
 
-func previousSecretAccountSecretName(name string) (*string, error) {
-	b, p, err := splitServiceAccountSecretName(name)
+// getTokenSecrets returns the secrets of the identity's service account
+func (m *Manager) getTokenSecrets(ctx context.Context, name string, namespace string) ([]corev1.Secret, error) {
+	ss, err := kube.GetServiceAccountSecrets(ctx, m.cli, name, namespace)
 	if err != nil {
 		return nil, err
 	}
+	m.log.V(2).Info("listed token secrets", "namespace", namespace, "identity", name, "count", len(ss))
+	return ss, nil
+}
 
-	if p == 0 {
-		return nil, fmt.Errorf("no prior secret to %s", name)
+// getLastTokenSecret returns the secret storing the identity's last token.
+// The last token is the one in the most recently created secret: the
+// candidates and the selected secret are logged to debug the selection.
+func (m *Manager) getLastTokenSecret(ctx context.Context, name string, namespace string) (*corev1.Secret, error) {
+	ss, err := m.getTokenSecrets(ctx, name, namespace)
+	if err != nil {
+		return nil, err
 	}
 
-	p--
-	n := fmt.Sprintf("%s-%d", b, p)
+	return m.selectLastTokenSecret(name, namespace, ss)
+}
+
+// selectLastTokenSecret returns the last created among the identity's token secrets
+func (m *Manager) selectLastTokenSecret(name string, namespace string, ss []corev1.Secret) (*corev1.Secret, error) {
+	for _, s := range ss {
+		m.log.V(3).Info("found token secret", "namespace", namespace, "identity", name, "secret", s.Name, "creationTimestamp", s.CreationTimestamp.UTC(), "issuedAt", s.Annotations[AnnotationTokenIssuedAt])
+	}
 
-	return &n, nil
+	s, err := lastCreatedSecret(ss)
+	if err != nil {
+		return nil, wrapIdentityError(err, name, namespace)
+	}
+
+	m.log.V(2).Info("selected last token secret", "namespace", namespace, "identity", name, "secret", s.Name, "creationTimestamp", s.CreationTimestamp.UTC())
+	return s, nil
+}
+
+// lastCreatedSecret returns the secret with the most recent creation timestamp.
+// Among secrets created in the same second, the one issued last is returned,
+// or the last one in the list if they have no issue time.
+func lastCreatedSecret(ss []corev1.Secret) (*corev1.Secret, error) {
+	if len(ss) == 0 {
+		return nil, kube.ErrSecretNotFound
+	}
+
+	l := &ss[0]
+	for i := 1; i < len(ss); i++ {
+		s := &ss[i]
+		switch c := s.CreationTimestamp.Compare(l.CreationTimestamp.Time); {
+		case c > 0:
+			l = s
+		case c == 0 && !issuedAt(s).Before(issuedAt(l)):
+			l = s
+		}
+	}
+	return l, nil
+}
+
+// issuedAt returns the time the secret was issued at by kid,
+// the zero time if it is not known
+func issuedAt(secret *corev1.Secret) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, secret.Annotations[AnnotationTokenIssuedAt])
+	return t
+}
+
+// createTokenSecret creates the secret for the given token version of the service account
+func (m *Manager) createTokenSecret(ctx context.Context, sa *corev1.ServiceAccount, version uint64) (*corev1.Secret, error) {
+	sn, err := m.namer().Name(sa.Name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := kube.CreateServiceAccountSecret(ctx, m.cli, sn, sa.Namespace, sa, m.opts.Labels, m.secretAnnotations())
+	if err != nil {
+		return nil, wrapVersionError(err, sa.Name, sa.Namespace, version)
+	}
+	m.log.V(1).Info("created token secret", "namespace", sa.Namespace, "identity", sa.Name, "version", version, "secret", s.Name)
+	return s, nil
+}
+
+// tokenVersion returns the version of the token stored in the given secret
+func (m *Manager) tokenVersion(secret *corev1.Secret) (uint64, error) {
+	v, err := m.namer().ParseVersion(secret.Annotations[corev1.ServiceAccountNameKey], secret.Name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: can not parse version from secret name '%s/%s': %v", ErrSecretMalformed, secret.Namespace, secret.Name, err)
+	}
+	return v, nil
 }
@@ -0,0 +1,386 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filariow/kid/pkg/simulator"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const testNamespace = "test-ns"
+
+// newFakeClient returns a simulated cluster whose objects are created one
+// second apart, as the last token is selected by creation timestamp
+func newFakeClient(objects ...runtime.Object) *simulator.Cluster {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	cli, err := simulator.NewCluster(simulator.Options{Clock: func() time.Time { return now }}, objects...)
+	if err != nil {
+		panic(err)
+	}
+	return cli
+}
+
+func secretNames(t *testing.T, cli kubernetes.Interface) []string {
+	t.Helper()
+
+	ss, err := cli.CoreV1().Secrets(testNamespace).List(context.TODO(), mv1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error listing secrets: %v", err)
+	}
+
+	nn := make([]string, 0, len(ss.Items))
+	for _, s := range ss.Items {
+		nn = append(nn, s.Name)
+	}
+	sort.Strings(nn)
+	return nn
+}
+
+func assertSecretNames(t *testing.T, cli kubernetes.Interface, expected ...string) {
+	t.Helper()
+
+	nn := secretNames(t, cli)
+	if len(nn) != len(expected) {
+		t.Fatalf("expected secrets %v, found %v", expected, nn)
+	}
+	for i := range nn {
+		if nn[i] != expected[i] {
+			t.Fatalf("expected secrets %v, found %v", expected, nn)
+		}
+	}
+}
+
+// createTestIdentity creates the identity and the given number of token versions after the first one
+func createTestIdentity(t *testing.T, cli kubernetes.Interface, name string, versions int) {
+	t.Helper()
+
+	if _, err := CreateIdentity(context.TODO(), cli, name, testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	for i := 0; i < versions; i++ {
+		if _, err := CreateNewTokenVersion(context.TODO(), cli, name, testNamespace); err != nil {
+			t.Fatalf("unexpected error creating token version: %v", err)
+		}
+	}
+}
+
+func TestCreateIdentity(t *testing.T) {
+	cli := newFakeClient()
+
+	i, err := CreateIdentity(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if i.Namespace != testNamespace || i.ServiceAccount != "app" || len(i.Secrets) != 1 || i.Secrets[0] != "app-key-1" {
+		t.Errorf("unexpected identity: %+v", i)
+	}
+
+	sa, err := cli.CoreV1().ServiceAccounts(testNamespace).Get(context.TODO(), "app", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected service account to be created: %v", err)
+	}
+
+	s, err := cli.CoreV1().Secrets(testNamespace).Get(context.TODO(), "app-key-1", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected secret to be created: %v", err)
+	}
+	if s.Type != corev1.SecretTypeServiceAccountToken {
+		t.Errorf("expected secret of type %s, found %s", corev1.SecretTypeServiceAccountToken, s.Type)
+	}
+	if n := s.Annotations[corev1.ServiceAccountNameKey]; n != "app" {
+		t.Errorf("expected secret annotated with service account 'app', found '%s'", n)
+	}
+	if len(s.OwnerReferences) != 1 || s.OwnerReferences[0].UID != sa.UID {
+		t.Errorf("expected secret owned by the service account, found %+v", s.OwnerReferences)
+	}
+}
+
+func TestCreateIdentityAlreadyExists(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 0)
+
+	if _, err := CreateIdentity(context.TODO(), cli, "app", testNamespace); err == nil {
+		t.Fatal("expected error creating an existing identity")
+	}
+	assertSecretNames(t, cli, "app-key-1")
+}
+
+func TestCreateIdentityWithOptions(t *testing.T) {
+	cli := newFakeClient()
+	m := NewManager(cli, WithOptions(Options{
+		Labels:      map[string]string{"team": "platform"},
+		Annotations: map[string]string{"owner": "me"},
+		TokenTTL:    time.Hour,
+	}))
+	if _, err := m.CreateIdentity(context.TODO(), "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+
+	sa, err := cli.CoreV1().ServiceAccounts(testNamespace).Get(context.TODO(), "app", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sa.Labels["team"] != "platform" || sa.Annotations["owner"] != "me" {
+		t.Errorf("expected default labels and annotations on service account, found %v %v", sa.Labels, sa.Annotations)
+	}
+
+	s, err := cli.CoreV1().Secrets(testNamespace).Get(context.TODO(), "app-key-1", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Labels["team"] != "platform" || s.Annotations["owner"] != "me" {
+		t.Errorf("expected default labels and annotations on secret, found %v %v", s.Labels, s.Annotations)
+	}
+
+	e, err := GetTokenExpiration(s)
+	if err != nil || e == nil {
+		t.Fatalf("expected token expiration, found %v, %v", e, err)
+	}
+	if d := time.Until(*e); d <= 0 || d > time.Hour {
+		t.Errorf("expected token to expire within an hour, expires at %v", e)
+	}
+}
+
+func TestCreateNewTokenVersion(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 0)
+
+	s, err := CreateNewTokenVersion(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Name != "app-key-2" {
+		t.Errorf("expected secret 'app-key-2', found '%s'", s.Name)
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-2")
+}
+
+func TestCreateNewTokenVersionIdentityNotFound(t *testing.T) {
+	cli := newFakeClient()
+
+	_, err := CreateNewTokenVersion(context.TODO(), cli, "app", testNamespace)
+	if !kerrors.IsNotFound(err) {
+		t.Fatalf("expected not found error, found %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 0)
+
+	s, err := BeginIdentityKeyRotation(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error beginning rotation: %v", err)
+	}
+	if s.Name != "app-key-2" {
+		t.Errorf("expected secret 'app-key-2', found '%s'", s.Name)
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-2")
+
+	d, err := CompleteIdentityKeyRotation(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error completing rotation: %v", err)
+	}
+	if *d != "app-key-1" {
+		t.Errorf("expected secret 'app-key-1' to be deleted, found '%s'", *d)
+	}
+	assertSecretNames(t, cli, "app-key-2")
+}
+
+func TestCompleteRotationWithoutPreviousVersion(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 0)
+
+	if _, err := CompleteIdentityKeyRotation(context.TODO(), cli, "app", testNamespace); err == nil {
+		t.Fatal("expected error completing a rotation not begun")
+	}
+	assertSecretNames(t, cli, "app-key-1")
+}
+
+func TestRevokeIdentityKey(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 2)
+
+	s, err := RevokeIdentityKey(context.TODO(), cli, "app", testNamespace, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *s != "app-key-2" {
+		t.Errorf("expected secret 'app-key-2' to be deleted, found '%s'", *s)
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-3")
+
+	if _, err := RevokeIdentityKey(context.TODO(), cli, "app", testNamespace, 2); !kerrors.IsNotFound(err) {
+		t.Errorf("expected not found error revoking a revoked version, found %v", err)
+	}
+}
+
+func TestRollbackIdentityKey(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 2)
+
+	if _, err := RevokeIdentityKey(context.TODO(), cli, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s, err := RollbackIdentityKey(context.TODO(), cli, "app", testNamespace, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Name != "app-key-2" {
+		t.Errorf("expected secret 'app-key-2', found '%s'", s.Name)
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-2", "app-key-3")
+}
+
+func TestRollbackIdentityKeyNotLowerThanLast(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 1)
+
+	for _, v := range []uint64{2, 3} {
+		if _, err := RollbackIdentityKey(context.TODO(), cli, "app", testNamespace, v); err == nil {
+			t.Errorf("expected error rolling back version %d", v)
+		}
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-2")
+}
+
+func TestRollbackIdentityKeyVersionZero(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 1)
+
+	if _, err := RollbackIdentityKey(context.TODO(), cli, "app", testNamespace, 0); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected error %v, found %v", ErrVersionNotFound, err)
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-2")
+}
+
+func TestRollbackIdentityKeyExisting(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 1)
+
+	if _, err := RollbackIdentityKey(context.TODO(), cli, "app", testNamespace, 1); !kerrors.IsAlreadyExists(err) {
+		t.Errorf("expected already exists error, found %v", err)
+	}
+}
+
+func TestKeyRotationWithSecretNameTemplate(t *testing.T) {
+	cli := newFakeClient()
+	m := NewManager(cli, WithSecretNamer(MustNewSecretNamer("{{.Identity}}-token-v{{.Version}}")))
+	if _, err := m.CreateIdentity(context.TODO(), "app-1", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	if _, err := m.BeginRotation(context.TODO(), "app-1", testNamespace); err != nil {
+		t.Fatalf("unexpected error beginning rotation: %v", err)
+	}
+	assertSecretNames(t, cli, "app-1-token-v1", "app-1-token-v2")
+
+	if _, err := m.CompleteRotation(context.TODO(), "app-1", testNamespace); err != nil {
+		t.Fatalf("unexpected error completing rotation: %v", err)
+	}
+	assertSecretNames(t, cli, "app-1-token-v2")
+}
+
+func TestGetTokenVersion(t *testing.T) {
+	s := &corev1.Secret{
+		ObjectMeta: mv1.ObjectMeta{
+			Name:        "app-1-key-3",
+			Namespace:   testNamespace,
+			Annotations: map[string]string{corev1.ServiceAccountNameKey: "app-1"},
+		},
+	}
+
+	v, err := GetTokenVersion(s)
+	if err != nil || v != 3 {
+		t.Errorf("expected version 3, found %d, %v", v, err)
+	}
+
+	s.Name = "app-1-token-3"
+	if _, err := GetTokenVersion(s); !errors.Is(err, ErrSecretMalformed) {
+		t.Errorf("expected malformed secret error for a secret name not matching the template, found %v", err)
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 0)
+
+	s, err := GetLastTokenSecret(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tk, err := GetToken(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(tk.Namespace) != testNamespace {
+		t.Errorf("expected namespace '%s', found '%s'", testNamespace, tk.Namespace)
+	}
+	if string(tk.CACrt) != string(cli.CACrt()) {
+		t.Error("expected the cluster's CA certificate")
+	}
+	sub, err := getTokenSubject(tk.Token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if eu := ServiceAccountUsername("app", testNamespace); sub != eu {
+		t.Errorf("expected token subject '%s', found '%s'", eu, sub)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	ll := []string{}
+	SetLogger(funcr.New(func(prefix, args string) { ll = append(ll, args) }, funcr.Options{Verbosity: 2}))
+	defer SetLogger(logr.Discard())
+
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 1)
+
+	// the last entries are the selection of the last token secret, the
+	// creation of the new one and the event recorded on the service account
+	l := ll[len(ll)-3]
+	if !strings.Contains(l, "selected last token secret") || !strings.Contains(l, "app-key-1") {
+		t.Errorf("expected the selection of the last token secret to be logged, found %v", ll)
+	}
+	if l := ll[len(ll)-2]; !strings.Contains(l, "created token secret") || !strings.Contains(l, "app-key-2") {
+		t.Errorf("expected the token secret creation to be logged, found %v", ll)
+	}
+	if l := ll[len(ll)-1]; !strings.Contains(l, "recorded event") || !strings.Contains(l, EventReasonTokenVersionCreated) {
+		t.Errorf("expected the recorded event to be logged, found %v", ll)
+	}
+}
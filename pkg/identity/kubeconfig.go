@@ -23,37 +23,57 @@ THE SOFTWARE.
 package identity
 
 import (
+	"bytes"
+	"fmt"
+
 	"github.com/filariow/kid/pkg/kube"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+const (
+	defaultKubeconfigCluster = "default-cluster"
+	defaultKubeconfigUser    = "default-user"
+	defaultKubeconfigContext = "default-context"
+)
+
 type GetKubeconfigOptions struct {
 	OverrideHost *string
 	User         *string
 	Namespace    *string
+	Context      *string
 }
 
-func GetKubeconfig(cli kubernetes.Clientset, token *ServiceAccountToken, opts GetKubeconfigOptions) ([]byte, error) {
-	cfg, err := kube.GetRESTConfig()
+func GetKubeconfig(cli kubernetes.Interface, token *ServiceAccountToken, opts GetKubeconfigOptions) ([]byte, error) {
+	cc, err := BuildKubeconfig(token, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	h := cfg.Host
+	return clientcmd.Write(*cc)
+}
+
+func BuildKubeconfig(token *ServiceAccountToken, opts GetKubeconfigOptions) (*clientcmdapi.Config, error) {
+	var h string
 	if opts.OverrideHost != nil {
 		h = *opts.OverrideHost
+	} else {
+		cfg, err := kube.GetRESTConfig()
+		if err != nil {
+			return nil, err
+		}
+		h = cfg.Host
 	}
 
 	cl := map[string]*clientcmdapi.Cluster{
-		"default-cluster": {
+		defaultKubeconfigCluster: {
 			Server:                   h,
 			CertificateAuthorityData: token.CACrt,
 		},
 	}
 
-	un := "default-user"
+	un := defaultKubeconfigUser
 	if opts.User != nil {
 		un = *opts.User
 	}
@@ -63,24 +83,61 @@ func GetKubeconfig(cli kubernetes.Clientset, token *ServiceAccountToken, opts Ge
 		},
 	}
 
+	cn := defaultKubeconfigContext
+	if opts.Context != nil {
+		cn = *opts.Context
+	}
 	ct := map[string]*clientcmdapi.Context{
-		"default-context": {
-			Cluster:  "default-cluster",
+		cn: {
+			Cluster:  defaultKubeconfigCluster,
 			AuthInfo: un,
 		},
 	}
 	if opts.Namespace != nil {
-		ct["default-context"].Namespace = *opts.Namespace
+		ct[cn].Namespace = *opts.Namespace
 	}
 
-	cc := clientcmdapi.Config{
+	return &clientcmdapi.Config{
 		Kind:           "Config",
 		APIVersion:     "v1",
 		Clusters:       cl,
 		Contexts:       ct,
 		AuthInfos:      ai,
-		CurrentContext: "default-context",
+		CurrentContext: cn,
+	}, nil
+}
+
+// MergeKubeconfigs merges the given kubeconfigs into a single one.
+// The current context of the result is the one of the first kubeconfig.
+func MergeKubeconfigs(cc ...*clientcmdapi.Config) (*clientcmdapi.Config, error) {
+	m := clientcmdapi.NewConfig()
+	m.Kind = "Config"
+	m.APIVersion = "v1"
+
+	for _, c := range cc {
+		if m.CurrentContext == "" {
+			m.CurrentContext = c.CurrentContext
+		}
+
+		for n, cl := range c.Clusters {
+			if e, ok := m.Clusters[n]; ok && (e.Server != cl.Server || !bytes.Equal(e.CertificateAuthorityData, cl.CertificateAuthorityData)) {
+				return nil, fmt.Errorf("can not merge kubeconfigs: conflicting definitions for cluster '%s'", n)
+			}
+			m.Clusters[n] = cl
+		}
+		for n, ai := range c.AuthInfos {
+			if _, ok := m.AuthInfos[n]; ok {
+				return nil, fmt.Errorf("can not merge kubeconfigs: user '%s' is defined more than once", n)
+			}
+			m.AuthInfos[n] = ai
+		}
+		for n, ct := range c.Contexts {
+			if _, ok := m.Contexts[n]; ok {
+				return nil, fmt.Errorf("can not merge kubeconfigs: context '%s' is defined more than once", n)
+			}
+			m.Contexts[n] = ct
+		}
 	}
 
-	return clientcmd.Write(cc)
+	return m, nil
 }
@@ -0,0 +1,111 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"sort"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ListManagedIdentities returns the identities in the namespace, that are the
+// service accounts having at least one token secret named after kid's convention
+func ListManagedIdentities(ctx context.Context, cli kubernetes.Interface, namespace string) ([]string, error) {
+	ss, err := kube.ListServiceAccountTokenSecrets(ctx, cli, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ii := map[string]struct{}{}
+	for _, s := range ss {
+		n := s.Annotations[corev1.ServiceAccountNameKey]
+		if _, ok := ii[n]; ok || n == "" {
+			continue
+		}
+
+		if _, err := ParseTokenVersion(n, s.Name); err == nil {
+			ii[n] = struct{}{}
+		}
+	}
+
+	nn := make([]string, 0, len(ii))
+	for n := range ii {
+		nn = append(nn, n)
+	}
+	sort.Strings(nn)
+	return nn, nil
+}
+
+// ListTokenVersions returns the sorted versions of the identity's tokens
+// existing on the cluster
+func ListTokenVersions(ctx context.Context, cli kubernetes.Interface, name string, namespace string) ([]uint64, error) {
+	ss, err := kube.GetServiceAccountSecrets(ctx, cli, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	vv := make([]uint64, 0, len(ss))
+	for _, s := range ss {
+		if v, err := ParseTokenVersion(name, s.Name); err == nil {
+			vv = append(vv, v)
+		}
+	}
+
+	sort.Slice(vv, func(i, j int) bool { return vv[i] < vv[j] })
+	return vv, nil
+}
+
+// ListRevokedTokenVersions returns the sorted versions lower than the last
+// one whose token does not exist anymore, that are the ones that can be rolled back.
+// Versions start at 1, the one of the token created with the identity.
+func ListRevokedTokenVersions(ctx context.Context, cli kubernetes.Interface, name string, namespace string) ([]uint64, error) {
+	vv, err := ListTokenVersions(ctx, cli, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return revokedVersions(vv), nil
+}
+
+// revokedVersions returns the versions missing from the given sorted ones,
+// lower than the last one
+func revokedVersions(vv []uint64) []uint64 {
+	rr := []uint64{}
+	if len(vv) == 0 {
+		return rr
+	}
+
+	ee := make(map[uint64]struct{}, len(vv))
+	for _, v := range vv {
+		ee[v] = struct{}{}
+	}
+
+	for v := uint64(1); v < vv[len(vv)-1]; v++ {
+		if _, ok := ee[v]; !ok {
+			rr = append(rr, v)
+		}
+	}
+	return rr
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListManagedIdentities(t *testing.T) {
+	unmanaged := &corev1.Secret{
+		ObjectMeta: mv1.ObjectMeta{
+			Name:        "other-token",
+			Namespace:   testNamespace,
+			Annotations: map[string]string{corev1.ServiceAccountNameKey: "other"},
+		},
+		Type: corev1.SecretTypeServiceAccountToken,
+	}
+	cli := newFakeClient(unmanaged)
+	createTestIdentity(t, cli, "bob", 0)
+	createTestIdentity(t, cli, "alice", 1)
+
+	ii, err := ListManagedIdentities(context.TODO(), cli, testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ii) != 2 || ii[0] != "alice" || ii[1] != "bob" {
+		t.Errorf("expected identities [alice bob], found %v", ii)
+	}
+}
+
+func TestListTokenVersions(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 4)
+	createTestIdentity(t, cli, "app-1", 0)
+	for _, v := range []uint64{2, 4} {
+		if _, err := RevokeIdentityKey(context.TODO(), cli, "app", testNamespace, v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	vv, err := ListTokenVersions(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertVersions(t, vv, 1, 3, 5)
+
+	rr, err := ListRevokedTokenVersions(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertVersions(t, rr, 2, 4)
+}
+
+func assertVersions(t *testing.T, vv []uint64, expected ...uint64) {
+	t.Helper()
+
+	if len(vv) != len(expected) {
+		t.Fatalf("expected versions %v, found %v", expected, vv)
+	}
+	for i := range vv {
+		if vv[i] != expected[i] {
+			t.Fatalf("expected versions %v, found %v", expected, vv)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Manager creates and manages identities and their token versions.
+//
+// The Manager, its options, its results and the sentinel errors are the
+// stable API of the package: they are only changed in backward compatible ways.
+// Errors returned by its methods can be checked with errors.Is against the
+// package's sentinel errors, like ErrIdentityExists or ErrVersionNotFound,
+// and inspected with errors.As as an *Error.
+//
+// A Manager is safe for concurrent use by multiple goroutines, provided its
+// client, logger, clock and auditor are.
+type Manager struct {
+	cli  kubernetes.Interface
+	opts Options
+	log  logr.Logger
+	now  func() time.Time
+	// auditor records the operations changing identities, if set
+	auditor Auditor
+	// operator is the user performing the operations, named in the events,
+	// resolved once on first use if not set with WithOperator
+	operator     string
+	operatorOnce sync.Once
+}
+
+// ManagerOption configures a Manager
+type ManagerOption func(*Manager)
+
+// NewManager returns a Manager operating with the given client
+func NewManager(cli kubernetes.Interface, opts ...ManagerOption) *Manager {
+	m := &Manager{
+		cli: cli,
+		log: logr.Discard(),
+		now: time.Now,
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// newDefaultManager returns a Manager with the default options, used by the package's functions
+func newDefaultManager(cli kubernetes.Interface) *Manager {
+	return NewManager(cli, WithLogger(logger))
+}
+
+// WithOptions sets all the defaults applied to the identities and tokens created
+func WithOptions(o Options) ManagerOption {
+	return func(m *Manager) {
+		m.opts = o
+	}
+}
+
+// WithSecretNamer sets how the secrets storing the tokens are named
+func WithSecretNamer(n *SecretNamer) ManagerOption {
+	return func(m *Manager) {
+		m.opts.SecretNamer = n
+	}
+}
+
+// WithLabels sets the labels set on created service accounts and secrets
+func WithLabels(labels map[string]string) ManagerOption {
+	return func(m *Manager) {
+		m.opts.Labels = labels
+	}
+}
+
+// WithAnnotations sets the annotations set on created service accounts and secrets
+func WithAnnotations(annotations map[string]string) ManagerOption {
+	return func(m *Manager) {
+		m.opts.Annotations = annotations
+	}
+}
+
+// WithTokenTTL sets the time to live recorded on created tokens
+func WithTokenTTL(ttl time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.opts.TokenTTL = ttl
+	}
+}
+
+// WithLogger sets the logger the operations are logged to, by default nothing is logged
+func WithLogger(l logr.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.log = l
+	}
+}
+
+// WithClock sets the function returning the current time, time.Now by default
+func WithClock(now func() time.Time) ManagerOption {
+	return func(m *Manager) {
+		m.now = now
+	}
+}
+
+// WithOperator sets the user performing the operations, named in the events
+// recorded on the service accounts. By default, it is the user the client
+// is authenticated as.
+func WithOperator(name string) ManagerOption {
+	return func(m *Manager) {
+		m.operator = name
+	}
+}
+
+// Identity is a service account managed as an identity
+type Identity struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// TokenVersions are the identity's existing token versions, sorted by version
+	TokenVersions []TokenVersion `json:"tokenVersions"`
+}
+
+// TokenVersion is a version of an identity's token and the secret storing it
+type TokenVersion struct {
+	Identity  string    `json:"identity"`
+	Namespace string    `json:"namespace"`
+	Version   uint64    `json:"version"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	// ExpiresAt is the expiration recorded on the token, if any
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// RotationStatus describes the token versions of an identity in use
+type RotationStatus struct {
+	Identity  string `json:"identity"`
+	Namespace string `json:"namespace"`
+	// Current is the last created token version, the one returned to consumers
+	Current TokenVersion `json:"current"`
+	// Previous are the other existing token versions, sorted by version
+	Previous []TokenVersion `json:"previous"`
+}
+
+// InProgress returns true if a rotation has begun and has not been completed yet,
+// that is if token versions other than the current one exist
+func (s *RotationStatus) InProgress() bool {
+	return len(s.Previous) > 0
+}
+
+// CreateIdentity creates the service account and its first token version
+func (m *Manager) CreateIdentity(ctx context.Context, name string, namespace string) (*Identity, error) {
+	_, s, err := m.createIdentity(ctx, name, namespace)
+	if err != nil {
+		return nil, m.audit(audit.ActionCreateIdentity, name, namespace, nil, err)
+	}
+
+	tv, err := m.auditTokenVersion(audit.ActionCreateIdentity, name, namespace, s)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Name: name, Namespace: namespace, TokenVersions: []TokenVersion{*tv}}, nil
+}
+
+// GetIdentity returns the identity and its token versions
+func (m *Manager) GetIdentity(ctx context.Context, name string, namespace string) (*Identity, error) {
+	if _, err := m.cli.CoreV1().ServiceAccounts(namespace).Get(ctx, name, mv1.GetOptions{}); err != nil {
+		return nil, wrapIdentityError(err, name, namespace)
+	}
+
+	tvs, err := m.ListTokenVersions(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Name: name, Namespace: namespace, TokenVersions: tvs}, nil
+}
+
+// ListIdentities returns the identities in the namespace, sorted by name.
+// Identities are the service accounts having at least one token secret
+// named after the naming template.
+func (m *Manager) ListIdentities(ctx context.Context, namespace string) ([]Identity, error) {
+	ss, err := kube.ListServiceAccountTokenSecrets(ctx, m.cli, namespace)
+	if err != nil {
+		return nil, err
+	}
+	m.log.V(2).Info("listed token secrets", "namespace", namespace, "count", len(ss))
+
+	ii := map[string]*Identity{}
+	for i := range ss {
+		tv, err := m.newTokenVersion(&ss[i])
+		if err != nil {
+			continue
+		}
+
+		k := tv.Namespace + "/" + tv.Identity
+		if _, ok := ii[k]; !ok {
+			ii[k] = &Identity{Name: tv.Identity, Namespace: tv.Namespace}
+		}
+		ii[k].TokenVersions = append(ii[k].TokenVersions, *tv)
+	}
+
+	rr := make([]Identity, 0, len(ii))
+	for _, i := range ii {
+		sortTokenVersions(i.TokenVersions)
+		rr = append(rr, *i)
+	}
+	sort.Slice(rr, func(i, j int) bool {
+		if rr[i].Namespace != rr[j].Namespace {
+			return rr[i].Namespace < rr[j].Namespace
+		}
+		return rr[i].Name < rr[j].Name
+	})
+	return rr, nil
+}
+
+// ListTokenVersions returns the identity's existing token versions, sorted by version
+func (m *Manager) ListTokenVersions(ctx context.Context, name string, namespace string) ([]TokenVersion, error) {
+	ss, err := m.getTokenSecrets(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tvs := make([]TokenVersion, 0, len(ss))
+	for i := range ss {
+		if tv, err := m.newTokenVersion(&ss[i]); err == nil {
+			tvs = append(tvs, *tv)
+		}
+	}
+	sortTokenVersions(tvs)
+	return tvs, nil
+}
+
+// CreateTokenVersion creates a token version following the last one
+func (m *Manager) CreateTokenVersion(ctx context.Context, name string, namespace string) (*TokenVersion, error) {
+	s, err := m.createNewTokenVersion(ctx, name, namespace)
+	if err != nil {
+		return nil, m.audit(audit.ActionCreateToken, name, namespace, nil, err)
+	}
+	return m.auditTokenVersion(audit.ActionCreateToken, name, namespace, s)
+}
+
+// RevokeTokenVersion deletes the given token version
+func (m *Manager) RevokeTokenVersion(ctx context.Context, name string, namespace string, version uint64) (*TokenVersion, error) {
+	sn, err := m.revokeTokenVersion(ctx, name, namespace, version)
+	if err := m.audit(audit.ActionRevokeToken, name, namespace, &version, err); err != nil {
+		return nil, err
+	}
+	return &TokenVersion{Identity: name, Namespace: namespace, Version: version, Secret: sn}, nil
+}
+
+// RollbackTokenVersion creates again the given token version, that must be
+// lower than the last one, so that it becomes the current one
+func (m *Manager) RollbackTokenVersion(ctx context.Context, name string, namespace string, version uint64) (*TokenVersion, error) {
+	s, err := m.rollbackTokenVersion(ctx, name, namespace, version)
+	if err := m.audit(audit.ActionRollbackToken, name, namespace, &version, err); err != nil {
+		return nil, err
+	}
+	return m.newTokenVersion(s)
+}
+
+// BeginRotation creates a new token version, keeping the previous one valid
+// until the rotation is completed. It fails with ErrRotationInProgress if
+// the previous rotation has not been completed.
+func (m *Manager) BeginRotation(ctx context.Context, name string, namespace string) (*RotationStatus, error) {
+	s, err := m.beginRotation(ctx, name, namespace)
+	if err != nil {
+		return nil, m.audit(audit.ActionBeginRotation, name, namespace, nil, err)
+	}
+	if _, err := m.auditTokenVersion(audit.ActionBeginRotation, name, namespace, s); err != nil {
+		return nil, err
+	}
+	return m.GetRotationStatus(ctx, name, namespace)
+}
+
+// CompleteRotation deletes the token version preceding the current one and returns it
+func (m *Manager) CompleteRotation(ctx context.Context, name string, namespace string) (*TokenVersion, error) {
+	sn, v, err := m.completeRotation(ctx, name, namespace)
+	if err != nil {
+		return nil, m.audit(audit.ActionCompleteRotation, name, namespace, nil, err)
+	}
+	if err := m.audit(audit.ActionCompleteRotation, name, namespace, &v, nil); err != nil {
+		return nil, err
+	}
+	return &TokenVersion{Identity: name, Namespace: namespace, Version: v, Secret: sn}, nil
+}
+
+// GetRotationStatus returns the identity's current token version and the other existing ones
+func (m *Manager) GetRotationStatus(ctx context.Context, name string, namespace string) (*RotationStatus, error) {
+	tvs, err := m.ListTokenVersions(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(tvs) == 0 {
+		return nil, newIdentityError(ErrIdentityNotFound, name, namespace, nil)
+	}
+
+	c := 0
+	for i := range tvs {
+		if !tvs[i].CreatedAt.Before(tvs[c].CreatedAt) {
+			c = i
+		}
+	}
+
+	pp := make([]TokenVersion, 0, len(tvs)-1)
+	pp = append(pp, tvs[:c]...)
+	pp = append(pp, tvs[c+1:]...)
+	return &RotationStatus{Identity: name, Namespace: namespace, Current: tvs[c], Previous: pp}, nil
+}
+
+// GetToken returns the identity's current token version and the token
+func (m *Manager) GetToken(ctx context.Context, name string, namespace string) (*TokenVersion, *ServiceAccountToken, error) {
+	s, err := m.getLastTokenSecret(ctx, name, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tv, err := m.newTokenVersion(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := GetToken(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tv, t, nil
+}
+
+// GetLastTokenSecret returns the secret storing the identity's last token,
+// that is the most recently created one
+func (m *Manager) GetLastTokenSecret(ctx context.Context, name string, namespace string) (*corev1.Secret, error) {
+	return m.getLastTokenSecret(ctx, name, namespace)
+}
+
+// GetTokenVersion returns the version of the token stored in the given secret
+func (m *Manager) GetTokenVersion(secret *corev1.Secret) (uint64, error) {
+	return m.tokenVersion(secret)
+}
+
+// ParseTokenVersion returns the version of the identity's token from the
+// name of the secret storing it
+func (m *Manager) ParseTokenVersion(identity string, secretName string) (uint64, error) {
+	return m.namer().ParseVersion(identity, secretName)
+}
+
+// ListRevokedTokenVersions returns the sorted versions lower than the last
+// one whose token does not exist anymore, that are the ones that can be rolled back
+func (m *Manager) ListRevokedTokenVersions(ctx context.Context, name string, namespace string) ([]uint64, error) {
+	tvs, err := m.ListTokenVersions(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	vv := make([]uint64, 0, len(tvs))
+	for _, tv := range tvs {
+		vv = append(vv, tv.Version)
+	}
+	return revokedVersions(vv), nil
+}
+
+// auditTokenVersion records the action that created the token version
+// stored in the secret and returns the token version
+func (m *Manager) auditTokenVersion(action string, name string, namespace string, secret *corev1.Secret) (*TokenVersion, error) {
+	tv, err := m.newTokenVersion(secret)
+	if err != nil {
+		return nil, m.audit(action, name, namespace, nil, err)
+	}
+	if err := m.audit(action, name, namespace, &tv.Version, nil); err != nil {
+		return nil, err
+	}
+	return tv, nil
+}
+
+// newTokenVersion returns the token version stored in the secret
+func (m *Manager) newTokenVersion(secret *corev1.Secret) (*TokenVersion, error) {
+	v, err := m.tokenVersion(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := GetTokenExpiration(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenVersion{
+		Identity:  secret.Annotations[corev1.ServiceAccountNameKey],
+		Namespace: secret.Namespace,
+		Version:   v,
+		Secret:    secret.Name,
+		CreatedAt: secret.CreationTimestamp.Time,
+		ExpiresAt: e,
+	}, nil
+}
+
+func sortTokenVersions(tvs []TokenVersion) {
+	sort.Slice(tvs, func(i, j int) bool { return tvs[i].Version < tvs[j].Version })
+}
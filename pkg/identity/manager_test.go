@@ -0,0 +1,424 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/filariow/kid/pkg/simulator"
+	"github.com/go-logr/logr/funcr"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestManagerLifecycle(t *testing.T) {
+	cli := newFakeClient()
+	m := NewManager(cli)
+
+	i, err := m.CreateIdentity(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	if i.Name != "app" || len(i.TokenVersions) != 1 || i.TokenVersions[0].Version != 1 || i.TokenVersions[0].Secret != "app-key-1" {
+		t.Errorf("unexpected identity: %+v", i)
+	}
+
+	rs, err := m.BeginRotation(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error beginning rotation: %v", err)
+	}
+	if !rs.InProgress() || rs.Current.Version != 2 || len(rs.Previous) != 1 || rs.Previous[0].Version != 1 {
+		t.Errorf("unexpected rotation status: %+v", rs)
+	}
+
+	tv, err := m.CompleteRotation(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error completing rotation: %v", err)
+	}
+	if tv.Version != 1 || tv.Secret != "app-key-1" {
+		t.Errorf("unexpected revoked token version: %+v", tv)
+	}
+
+	rs, err = m.GetRotationStatus(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error getting rotation status: %v", err)
+	}
+	if rs.InProgress() || rs.Current.Version != 2 {
+		t.Errorf("unexpected rotation status: %+v", rs)
+	}
+
+	tv, err = m.RollbackTokenVersion(context.TODO(), "app", testNamespace, 1)
+	if err != nil {
+		t.Fatalf("unexpected error rolling back: %v", err)
+	}
+	if tv.Version != 1 || tv.CreatedAt.IsZero() {
+		t.Errorf("unexpected rolled back token version: %+v", tv)
+	}
+
+	tv, tk, err := m.GetToken(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error getting token: %v", err)
+	}
+	if tv.Version != 1 || len(tk.Token) == 0 {
+		t.Errorf("expected the rolled back version to be the current one, found %+v", tv)
+	}
+
+	if _, err := m.RevokeTokenVersion(context.TODO(), "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error revoking: %v", err)
+	}
+	i, err = m.GetIdentity(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error getting identity: %v", err)
+	}
+	if len(i.TokenVersions) != 1 || i.TokenVersions[0].Version != 1 {
+		t.Errorf("unexpected identity: %+v", i)
+	}
+}
+
+func TestManagerErrors(t *testing.T) {
+	cli := newFakeClient()
+	m := NewManager(cli)
+	if _, err := m.CreateIdentity(context.TODO(), "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	if _, err := m.CreateTokenVersion(context.TODO(), "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating token version: %v", err)
+	}
+
+	_, err := m.CreateIdentity(context.TODO(), "app", testNamespace)
+	assertError(t, err, ErrAlreadyExists)
+	assertError(t, err, ErrIdentityExists)
+
+	_, err = m.BeginRotation(context.TODO(), "app", testNamespace)
+	assertError(t, err, ErrRotationInProgress)
+
+	_, err = m.GetIdentity(context.TODO(), "missing", testNamespace)
+	assertError(t, err, ErrNotFound)
+	assertError(t, err, ErrIdentityNotFound)
+	var ierr *Error
+	if !errors.As(err, &ierr) || ierr.Identity != "missing" || ierr.Namespace != testNamespace || ierr.Version != nil {
+		t.Errorf("expected an identity error, found %#v", err)
+	}
+	if !kerrors.IsNotFound(err) {
+		t.Errorf("expected the API server error to be wrapped, found %v", err)
+	}
+
+	_, err = m.CreateTokenVersion(context.TODO(), "missing", testNamespace)
+	assertError(t, err, ErrNotFound)
+
+	_, err = m.RevokeTokenVersion(context.TODO(), "app", testNamespace, 5)
+	assertError(t, err, ErrVersionNotFound)
+	if !errors.As(err, &ierr) || ierr.Version == nil || *ierr.Version != 5 {
+		t.Errorf("expected a token version error, found %#v", err)
+	}
+
+	_, err = m.RollbackTokenVersion(context.TODO(), "app", testNamespace, 2)
+	assertError(t, err, ErrVersionConflict)
+
+	_, err = m.RollbackTokenVersion(context.TODO(), "app", testNamespace, 1)
+	assertError(t, err, ErrVersionConflict)
+
+	if _, err := m.RevokeTokenVersion(context.TODO(), "app", testNamespace, 1); err != nil {
+		t.Fatalf("unexpected error revoking: %v", err)
+	}
+	_, err = m.CompleteRotation(context.TODO(), "app", testNamespace)
+	assertError(t, err, ErrVersionNotFound)
+}
+
+func TestManagerOptions(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	ll := []string{}
+	m := NewManager(newFakeClient(),
+		WithSecretNamer(MustNewSecretNamer("{{.Identity}}-token-v{{.Version}}")),
+		WithLabels(map[string]string{"team": "a"}),
+		WithTokenTTL(time.Hour),
+		WithClock(func() time.Time { return now }),
+		WithLogger(funcr.New(func(prefix, args string) { ll = append(ll, args) }, funcr.Options{Verbosity: 1})),
+	)
+
+	i, err := m.CreateIdentity(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+
+	tv := i.TokenVersions[0]
+	if tv.Secret != "app-token-v1" {
+		t.Errorf("expected secret 'app-token-v1', found '%s'", tv.Secret)
+	}
+	if tv.ExpiresAt == nil || !tv.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected token to expire at %v, found %v", now.Add(time.Hour), tv.ExpiresAt)
+	}
+
+	s, err := m.cli.CoreV1().ServiceAccounts(testNamespace).Get(context.TODO(), "app", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Labels["team"] != "a" {
+		t.Errorf("expected service account labels, found %v", s.Labels)
+	}
+
+	if len(ll) != 3 || !strings.Contains(ll[1], "app-token-v1") || !strings.Contains(ll[2], "recorded event") {
+		t.Errorf("expected service account and secret creation and the event to be logged, found %v", ll)
+	}
+
+	ii, err := m.ListIdentities(context.TODO(), testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error listing identities: %v", err)
+	}
+	if len(ii) != 1 || ii[0].Name != "app" {
+		t.Errorf("unexpected identities: %+v", ii)
+	}
+	if ii, _ := NewManager(m.cli).ListIdentities(context.TODO(), testNamespace); len(ii) != 0 {
+		t.Errorf("expected no identity with the default naming template, found %+v", ii)
+	}
+}
+
+func TestManagerEvents(t *testing.T) {
+	m := NewManager(newFakeClient(), WithOperator("alice"))
+
+	ctx := context.TODO()
+	if _, err := m.CreateIdentity(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	if _, err := m.BeginRotation(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error beginning rotation: %v", err)
+	}
+	if _, err := m.CompleteRotation(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error completing rotation: %v", err)
+	}
+	if _, err := m.CreateTokenVersion(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating token version: %v", err)
+	}
+	if _, err := m.RevokeTokenVersion(ctx, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error revoking: %v", err)
+	}
+	if _, err := m.RollbackTokenVersion(ctx, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error rolling back: %v", err)
+	}
+	if _, err := m.RevokeTokenVersion(ctx, "app", testNamespace, 9); err == nil {
+		t.Fatalf("expected error revoking a missing version")
+	}
+
+	sa, err := m.cli.CoreV1().ServiceAccounts(testNamespace).Get(ctx, "app", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ee, err := m.cli.CoreV1().Events(testNamespace).List(ctx, mv1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error listing events: %v", err)
+	}
+	sort.Slice(ee.Items, func(i, j int) bool { return ee.Items[i].CreationTimestamp.Before(&ee.Items[j].CreationTimestamp) })
+
+	expected := []struct{ reason, message string }{
+		{EventReasonIdentityCreated, "Identity created with token version 1 in secret app-key-1 by alice"},
+		{EventReasonTokenRotationBegun, "Token rotation begun, token version 2 created in secret app-key-2 by alice"},
+		{EventReasonTokenRotationCompleted, "Token rotation completed, token version 1 revoked, secret app-key-1 deleted by alice"},
+		{EventReasonTokenVersionCreated, "Token version 3 created in secret app-key-3 by alice"},
+		{EventReasonTokenRevoked, "Token version 2 revoked, secret app-key-2 deleted by alice"},
+		{EventReasonTokenRolledBack, "Token version 2 rolled back in secret app-key-2 by alice"},
+	}
+	if len(ee.Items) != len(expected) {
+		t.Fatalf("expected %d events, found %d: %+v", len(expected), len(ee.Items), ee.Items)
+	}
+	for i, e := range ee.Items {
+		if e.Reason != expected[i].reason || e.Message != expected[i].message {
+			t.Errorf("expected event %d '%s: %s', found '%s: %s'", i, expected[i].reason, expected[i].message, e.Reason, e.Message)
+		}
+		if o := e.InvolvedObject; o.Kind != "ServiceAccount" || o.Name != "app" || o.UID != sa.UID {
+			t.Errorf("expected event %d on the service account, found %+v", i, o)
+		}
+	}
+}
+
+func TestManagerEventsForbidden(t *testing.T) {
+	cli := newFakeClient()
+	cli.PrependReactor("create", "events", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewForbidden(schema.GroupResource{Resource: "events"}, "", errors.New("denied"))
+	})
+	ll := []string{}
+	m := NewManager(cli, WithOperator("alice"), WithLogger(funcr.New(func(prefix, args string) { ll = append(ll, args) }, funcr.Options{})))
+
+	if _, err := m.CreateIdentity(context.TODO(), "app", testNamespace); err != nil {
+		t.Fatalf("expected the identity to be created without events, found error: %v", err)
+	}
+	if len(ll) != 1 || !strings.Contains(ll[0], "can not record event") || !strings.Contains(ll[0], "denied") {
+		t.Errorf("expected the failure to record the event to be logged, found %v", ll)
+	}
+}
+
+func TestManagerConcurrentOperator(t *testing.T) {
+	cli := newFakeClient()
+	var reviews int32
+	cli.PrependReactor("create", "selfsubjectreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&reviews, 1)
+		return false, nil, nil
+	})
+	m := NewManager(cli)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(n string) {
+			defer wg.Done()
+			if _, err := m.CreateIdentity(context.TODO(), n, testNamespace); err != nil {
+				t.Errorf("unexpected error creating identity '%s': %v", n, err)
+			}
+		}(fmt.Sprintf("app-%d", i))
+	}
+	wg.Wait()
+
+	if r := atomic.LoadInt32(&reviews); r != 1 {
+		t.Errorf("expected the operator to be resolved once, found %d reviews", r)
+	}
+	ee, err := cli.CoreV1().Events(testNamespace).List(context.TODO(), mv1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error listing events: %v", err)
+	}
+	for _, e := range ee.Items {
+		if !strings.HasSuffix(e.Message, " by "+simulator.Username) {
+			t.Errorf("expected event to name operator '%s', found '%s'", simulator.Username, e.Message)
+		}
+	}
+}
+
+func TestManagerEventsUnknownOperator(t *testing.T) {
+	cli := newFakeClient()
+	cli.PrependReactor("create", "selfsubjectreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewNotFound(action.GetResource().GroupResource(), "")
+	})
+
+	if _, err := NewManager(cli).CreateIdentity(context.TODO(), "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	ee, err := cli.CoreV1().Events(testNamespace).List(context.TODO(), mv1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error listing events: %v", err)
+	}
+	if len(ee.Items) != 1 || !strings.HasSuffix(ee.Items[0].Message, " by "+kube.UnknownUsername) {
+		t.Errorf("expected the event to name operator '%s', found %+v", kube.UnknownUsername, ee.Items)
+	}
+}
+
+// recordingAuditor keeps the records audited, failing with err if set
+type recordingAuditor struct {
+	rr  []audit.Record
+	err error
+}
+
+func (a *recordingAuditor) Audit(r audit.Record) error {
+	a.rr = append(a.rr, r)
+	return a.err
+}
+
+func TestManagerAuditor(t *testing.T) {
+	a := &recordingAuditor{}
+	m := NewManager(newFakeClient(), WithAuditor(a))
+
+	ctx := context.TODO()
+	if _, err := m.CreateIdentity(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating identity: %v", err)
+	}
+	if _, err := m.BeginRotation(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error beginning rotation: %v", err)
+	}
+	if _, err := m.BeginRotation(ctx, "app", testNamespace); err == nil {
+		t.Fatalf("expected error beginning a rotation in progress")
+	}
+	if _, err := m.CompleteRotation(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error completing rotation: %v", err)
+	}
+	if _, err := m.CreateTokenVersion(ctx, "app", testNamespace); err != nil {
+		t.Fatalf("unexpected error creating token version: %v", err)
+	}
+	if _, err := m.RevokeTokenVersion(ctx, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error revoking: %v", err)
+	}
+	if _, err := m.RollbackTokenVersion(ctx, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error rolling back: %v", err)
+	}
+
+	expected := []struct {
+		action  string
+		version uint64
+		result  string
+	}{
+		{audit.ActionCreateIdentity, 1, audit.ResultSuccess},
+		{audit.ActionBeginRotation, 2, audit.ResultSuccess},
+		{audit.ActionBeginRotation, 1, audit.ResultFailure},
+		{audit.ActionCompleteRotation, 1, audit.ResultSuccess},
+		{audit.ActionCreateToken, 3, audit.ResultSuccess},
+		{audit.ActionRevokeToken, 2, audit.ResultSuccess},
+		{audit.ActionRollbackToken, 2, audit.ResultSuccess},
+	}
+	if len(a.rr) != len(expected) {
+		t.Fatalf("expected %d records, found %d: %+v", len(expected), len(a.rr), a.rr)
+	}
+	for i, r := range a.rr {
+		var v uint64
+		if r.Version != nil {
+			v = *r.Version
+		}
+		if r.Action != expected[i].action || v != expected[i].version || r.Result != expected[i].result {
+			t.Errorf("expected record %d '%s' of version %d with result '%s', found %+v", i, expected[i].action, expected[i].version, expected[i].result, r)
+		}
+		if r.Identity != "app" || r.Namespace != testNamespace {
+			t.Errorf("expected record %d on identity '%s/app', found '%s/%s'", i, testNamespace, r.Namespace, r.Identity)
+		}
+	}
+	if r := a.rr[2]; !strings.Contains(r.Reason, "rotation") {
+		t.Errorf("expected the failure reason to be recorded, found '%s'", r.Reason)
+	}
+}
+
+func TestManagerAuditorError(t *testing.T) {
+	aerr := errors.New("disk full")
+	m := NewManager(newFakeClient(), WithAuditor(&recordingAuditor{err: aerr}))
+
+	_, err := m.CreateIdentity(context.TODO(), "app", testNamespace)
+	assertError(t, err, aerr)
+
+	_, err = m.CreateIdentity(context.TODO(), "app", testNamespace)
+	assertError(t, err, aerr)
+	assertError(t, err, ErrIdentityExists)
+}
+
+func assertError(t *testing.T, err error, expected error) {
+	t.Helper()
+
+	if !errors.Is(err, expected) {
+		t.Errorf("expected error '%v', found %v", expected, err)
+	}
+}
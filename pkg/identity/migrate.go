@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SecretMigration describes the migration of the secret storing a token version
+// from a naming template to the configured one
+type SecretMigration struct {
+	Version uint64
+	From    string
+	To      string
+	// Created is true if the secret named after the Manager's template has been created
+	Created bool
+	// Deleted is true if the secret named after the old template has been deleted
+	Deleted bool
+}
+
+// MigrateSecretNames creates a secret named after DefaultSecretNameTemplate for each
+// of the identity's token versions stored in a secret named after the given one.
+// If deleteOld is true, the old secrets are deleted once the new ones exist.
+//
+// Secrets can not be renamed and the token controller issues a new token for
+// each new secret, so migrated versions hold new tokens: consumers of the old
+// tokens have to be updated before the old secrets are deleted.
+func MigrateSecretNames(ctx context.Context, cli kubernetes.Interface, name string, namespace string, from *SecretNamer, deleteOld bool) ([]SecretMigration, error) {
+	return newDefaultManager(cli).MigrateSecretNames(ctx, name, namespace, from, deleteOld)
+}
+
+// MigrateSecretNames creates a secret named after the Manager's template for each
+// of the identity's token versions stored in a secret named after the given one.
+// If deleteOld is true, the old secrets are deleted once the new ones exist.
+func (m *Manager) MigrateSecretNames(ctx context.Context, name string, namespace string, from *SecretNamer, deleteOld bool) ([]SecretMigration, error) {
+	mm, err := m.migrateSecretNames(ctx, name, namespace, from, deleteOld)
+
+	// the migration is recorded if it failed or changed any secret
+	changed := false
+	for _, sm := range mm {
+		changed = changed || sm.Created || sm.Deleted
+	}
+	if changed || err != nil {
+		err = m.audit(audit.ActionMigrateSecrets, name, namespace, nil, err)
+	}
+	return mm, err
+}
+
+func (m *Manager) migrateSecretNames(ctx context.Context, name string, namespace string, from *SecretNamer, deleteOld bool) ([]SecretMigration, error) {
+	sa, err := m.cli.CoreV1().ServiceAccounts(namespace).Get(ctx, name, mv1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := kube.GetServiceAccountSecrets(ctx, m.cli, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]struct{}, len(ss))
+	for _, s := range ss {
+		existing[s.Name] = struct{}{}
+	}
+
+	mm := []SecretMigration{}
+	for _, s := range ss {
+		v, err := from.ParseVersion(name, s.Name)
+		if err != nil {
+			continue
+		}
+
+		to, err := m.namer().Name(name, v)
+		if err != nil {
+			return nil, err
+		}
+		if to != s.Name {
+			mm = append(mm, SecretMigration{Version: v, From: s.Name, To: to})
+		}
+	}
+	sort.Slice(mm, func(i, j int) bool { return mm[i].Version < mm[j].Version })
+
+	// the last token is the one in the secret created last: the secret of its
+	// version is created after the other ones, so that it is still the last token
+	cur, hasCur := m.lastTokenVersion(name, from, ss)
+	created := false
+	create := func(i int) error {
+		sm := mm[i]
+		if _, ok := existing[sm.To]; ok {
+			return nil
+		}
+
+		if _, err := kube.CreateServiceAccountSecret(ctx, m.cli, sm.To, namespace, sa, m.opts.Labels, m.secretAnnotations()); err != nil {
+			return err
+		}
+		m.log.V(1).Info("created token secret", "namespace", namespace, "identity", name, "version", sm.Version, "secret", sm.To)
+		mm[i].Created, created = true, true
+		return nil
+	}
+	for i, sm := range mm {
+		if !hasCur || sm.Version != cur {
+			if err := create(i); err != nil {
+				return mm, err
+			}
+		}
+	}
+	for i, sm := range mm {
+		if hasCur && sm.Version == cur {
+			if err := create(i); err != nil {
+				return mm, err
+			}
+		}
+	}
+
+	if created && hasCur {
+		l, err := m.getLastTokenSecret(ctx, name, namespace)
+		if err != nil {
+			return mm, err
+		}
+		if v, err := m.tokenVersion(l); err != nil || v != cur {
+			return mm, fmt.Errorf("%w: the last token of identity '%s/%s' is not version %d anymore, it is in secret '%s'", ErrSecretMalformed, namespace, name, cur, l.Name)
+		}
+	}
+
+	if deleteOld {
+		for i, sm := range mm {
+			if err := kube.DeleteServiceAccountSecret(ctx, m.cli, sm.From, namespace); err != nil {
+				return mm, err
+			}
+			m.log.V(1).Info("deleted token secret", "namespace", namespace, "identity", name, "version", sm.Version, "secret", sm.From)
+			mm[i].Deleted = true
+		}
+	}
+
+	return mm, nil
+}
+
+// lastTokenVersion returns the version of the token in the secret created last,
+// named after the given template or the Manager's one
+func (m *Manager) lastTokenVersion(name string, from *SecretNamer, ss []corev1.Secret) (uint64, bool) {
+	l, err := lastCreatedSecret(ss)
+	if err != nil {
+		return 0, false
+	}
+
+	for _, n := range []*SecretNamer{from, m.namer()} {
+		if v, err := n.ParseVersion(name, l.Name); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
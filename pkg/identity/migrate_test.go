@@ -0,0 +1,141 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMigrateSecretNames(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 1)
+
+	m := NewManager(cli, WithSecretNamer(MustNewSecretNamer("{{.Identity}}-token-v{{.Version}}")))
+	from := MustNewSecretNamer(DefaultSecretNameTemplate)
+	mm, err := m.MigrateSecretNames(context.TODO(), "app", testNamespace, from, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mm) != 2 || mm[0].To != "app-token-v1" || mm[1].To != "app-token-v2" || !mm[0].Created || !mm[1].Created || mm[0].Deleted {
+		t.Errorf("unexpected migrations: %+v", mm)
+	}
+	assertSecretNames(t, cli, "app-key-1", "app-key-2", "app-token-v1", "app-token-v2")
+
+	if _, err := m.CreateTokenVersion(context.TODO(), "app", testNamespace); err != nil {
+		t.Fatalf("expected the last migrated version to be the last token: %v", err)
+	}
+
+	mm, err = m.MigrateSecretNames(context.TODO(), "app", testNamespace, from, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mm) != 2 || mm[0].Created || !mm[0].Deleted || !mm[1].Deleted {
+		t.Errorf("unexpected migrations: %+v", mm)
+	}
+	assertSecretNames(t, cli, "app-token-v1", "app-token-v2", "app-token-v3")
+}
+
+func TestMigrateSecretNamesKeepsLastToken(t *testing.T) {
+	cli := newFakeClient()
+	createTestIdentity(t, cli, "app", 2)
+	if _, err := RevokeIdentityKey(context.TODO(), cli, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error revoking: %v", err)
+	}
+	if _, err := RollbackIdentityKey(context.TODO(), cli, "app", testNamespace, 2); err != nil {
+		t.Fatalf("unexpected error rolling back: %v", err)
+	}
+
+	m := NewManager(cli, WithSecretNamer(MustNewSecretNamer("{{.Identity}}-token-v{{.Version}}")))
+	from := MustNewSecretNamer(DefaultSecretNameTemplate)
+	if _, err := m.MigrateSecretNames(context.TODO(), "app", testNamespace, from, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSecretNames(t, cli, "app-token-v1", "app-token-v2", "app-token-v3")
+
+	s, err := m.GetLastTokenSecret(context.TODO(), "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Name != "app-token-v2" {
+		t.Errorf("expected the rolled back version 2 to still be the last token, found secret '%s'", s.Name)
+	}
+}
+
+func TestLastCreatedSecret(t *testing.T) {
+	newSecret := func(name string, created time.Time, issued string) corev1.Secret {
+		s := corev1.Secret{ObjectMeta: mv1.ObjectMeta{Name: name, CreationTimestamp: mv1.NewTime(created)}}
+		if issued != "" {
+			s.Annotations = map[string]string{AnnotationTokenIssuedAt: issued}
+		}
+		return s
+	}
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name     string
+		secrets  []corev1.Secret
+		expected string
+	}{
+		{
+			name: "most recent creation timestamp",
+			secrets: []corev1.Secret{
+				newSecret("app-key-2", t0.Add(time.Second), "2023-01-01T00:00:00.1Z"),
+				newSecret("app-key-1", t0, "2023-01-01T00:00:00.9Z"),
+			},
+			expected: "app-key-2",
+		},
+		{
+			name: "same second, issued last",
+			secrets: []corev1.Secret{
+				newSecret("app-key-2", t0, "2023-01-01T00:00:00.7Z"),
+				newSecret("app-key-1", t0, "2023-01-01T00:00:00.3Z"),
+			},
+			expected: "app-key-2",
+		},
+		{
+			name: "same second, no issue time",
+			secrets: []corev1.Secret{
+				newSecret("app-key-2", t0, ""),
+				newSecret("app-key-1", t0, ""),
+			},
+			expected: "app-key-1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := lastCreatedSecret(tc.secrets)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s.Name != tc.expected {
+				t.Errorf("expected secret '%s', found '%s'", tc.expected, s.Name)
+			}
+		})
+	}
+}
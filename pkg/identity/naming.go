@@ -0,0 +1,154 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultSecretNameTemplate is the default template for the names of the
+// secrets storing the identities' tokens
+const DefaultSecretNameTemplate = "{{.Identity}}-key-{{.Version}}"
+
+// ErrInvalidSecretNameTemplate is returned when a secret name template can not
+// be used both to build and to parse secret names
+var ErrInvalidSecretNameTemplate = errors.New("invalid secret name template")
+
+// versionPlaceholder is rendered in place of the version to find where the
+// version is in secret names. It can not be part of a valid secret name.
+const versionPlaceholder = "\x00"
+
+// SecretNamer builds the names of the secrets storing the identities' tokens
+// from a template, and parses the token versions back from them.
+//
+// The template is a text/template with the fields .Identity and .Version,
+// e.g. '{{.Identity}}-token-v{{.Version}}'. As the identity is always known
+// when parsing, versions are unambiguous even if identity names end with '-<digits>'.
+type SecretNamer struct {
+	text string
+	tmpl *template.Template
+}
+
+type secretNameData struct {
+	Identity string
+	Version  interface{}
+}
+
+var defaultSecretNamer = MustNewSecretNamer(DefaultSecretNameTemplate)
+
+// NewSecretNamer parses the template and checks it renders valid secret names
+// holding the version exactly once
+func NewSecretNamer(text string) (*SecretNamer, error) {
+	t, err := template.New("secret-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %v", ErrInvalidSecretNameTemplate, text, err)
+	}
+	n := &SecretNamer{text: text, tmpl: t}
+
+	p, err := n.render("identity", versionPlaceholder)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %v", ErrInvalidSecretNameTemplate, text, err)
+	}
+	if strings.Count(p, versionPlaceholder) != 1 {
+		return nil, fmt.Errorf("%w '%s': the version has to be rendered exactly once", ErrInvalidSecretNameTemplate, text)
+	}
+	if !strings.Contains(p, "identity") {
+		return nil, fmt.Errorf("%w '%s': the identity has to be rendered", ErrInvalidSecretNameTemplate, text)
+	}
+
+	s, err := n.Name("identity", 1)
+	if err != nil {
+		return nil, err
+	}
+	if ee := validation.IsDNS1123Subdomain(s); len(ee) > 0 {
+		return nil, fmt.Errorf("%w '%s': rendered name '%s' is not valid: %s", ErrInvalidSecretNameTemplate, text, s, strings.Join(ee, ", "))
+	}
+	return n, nil
+}
+
+// MustNewSecretNamer is like NewSecretNamer but panics if the template is invalid
+func MustNewSecretNamer(text string) *SecretNamer {
+	n, err := NewSecretNamer(text)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// String returns the template
+func (n *SecretNamer) String() string {
+	return n.text
+}
+
+// Name returns the name of the secret storing the given version of the identity's token
+func (n *SecretNamer) Name(identity string, version uint64) (string, error) {
+	s, err := n.render(identity, version)
+	if err != nil {
+		return "", fmt.Errorf("%w '%s': %v", ErrInvalidSecretNameTemplate, n.text, err)
+	}
+	return s, nil
+}
+
+// ParseVersion returns the version of the identity's token stored in the secret with
+// the given name. It fails if the name does not match the template.
+func (n *SecretNamer) ParseVersion(identity string, secretName string) (uint64, error) {
+	p, err := n.render(identity, versionPlaceholder)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix, suffix, _ := strings.Cut(p, versionPlaceholder)
+	vs, ok := strings.CutPrefix(secretName, prefix)
+	if ok {
+		vs, ok = strings.CutSuffix(vs, suffix)
+	}
+	if !ok {
+		return 0, fmt.Errorf("secret name '%s' does not match template '%s' for identity '%s'", secretName, n.text, identity)
+	}
+
+	v, err := strconv.ParseUint(vs, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version in secret name '%s': %w", secretName, err)
+	}
+
+	// versions with leading zeros are not built by the template
+	if s, err := n.Name(identity, v); err != nil || s != secretName {
+		return 0, fmt.Errorf("secret name '%s' does not match template '%s' for identity '%s'", secretName, n.text, identity)
+	}
+	return v, nil
+}
+
+func (n *SecretNamer) render(identity string, version interface{}) (string, error) {
+	var b bytes.Buffer
+	if err := n.tmpl.Execute(&b, secretNameData{Identity: identity, Version: version}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
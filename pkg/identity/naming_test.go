@@ -0,0 +1,109 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewSecretNamer(t *testing.T) {
+	tt := []struct {
+		template string
+		valid    bool
+	}{
+		{template: DefaultSecretNameTemplate, valid: true},
+		{template: "{{.Identity}}-token-v{{.Version}}", valid: true},
+		{template: "token-{{.Version}}-{{.Identity}}", valid: true},
+		{template: "{{.Identity}}", valid: false},
+		{template: "token-{{.Version}}", valid: false},
+		{template: "{{.Identity}}-{{.Version}}-{{.Version}}", valid: false},
+		{template: "{{.Identity}}_{{.Version}}", valid: false},
+		{template: "{{.Identity}}-{{.Unknown}}", valid: false},
+		{template: "{{.Identity}-{{.Version}}", valid: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.template, func(t *testing.T) {
+			_, err := NewSecretNamer(tc.template)
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidSecretNameTemplate) {
+				t.Errorf("expected invalid template error, found %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretNamerName(t *testing.T) {
+	n := MustNewSecretNamer("{{.Identity}}-token-v{{.Version}}")
+
+	s, err := n.Name("app", 12)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s != "app-token-v12" {
+		t.Errorf("expected 'app-token-v12', found '%s'", s)
+	}
+}
+
+func TestSecretNamerParseVersion(t *testing.T) {
+	tt := []struct {
+		name       string
+		template   string
+		identity   string
+		secretName string
+		version    uint64
+		valid      bool
+	}{
+		{name: "default", template: DefaultSecretNameTemplate, identity: "app", secretName: "app-key-3", version: 3, valid: true},
+		{name: "identity ending in digits", template: DefaultSecretNameTemplate, identity: "app-2", secretName: "app-2-key-3", version: 3, valid: true},
+		{name: "other identity ending in digits", template: DefaultSecretNameTemplate, identity: "app", secretName: "app-2-key-3", valid: false},
+		{name: "custom", template: "{{.Identity}}-token-v{{.Version}}", identity: "app-10", secretName: "app-10-token-v10", version: 10, valid: true},
+		{name: "prefix", template: "token-{{.Version}}-{{.Identity}}", identity: "app", secretName: "token-7-app", version: 7, valid: true},
+		{name: "other template", template: "{{.Identity}}-token-v{{.Version}}", identity: "app", secretName: "app-key-1", valid: false},
+		{name: "leading zeros", template: DefaultSecretNameTemplate, identity: "app", secretName: "app-key-01", valid: false},
+		{name: "not a number", template: DefaultSecretNameTemplate, identity: "app", secretName: "app-key-x", valid: false},
+		{name: "no version", template: DefaultSecretNameTemplate, identity: "app", secretName: "app-key-", valid: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := MustNewSecretNamer(tc.template).ParseVersion(tc.identity, tc.secretName)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("expected error, found version %d", v)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v != tc.version {
+				t.Errorf("expected version %d, found %d", tc.version, v)
+			}
+		})
+	}
+}
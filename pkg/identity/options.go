@@ -0,0 +1,103 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// AnnotationTokenExpiresAt records on token secrets the time the token expires at
+	AnnotationTokenExpiresAt = "kid.filariow.github.io/expires-at"
+	// AnnotationTokenIssuedAt records on token secrets the time kid created them at,
+	// with nanosecond precision: it orders the secrets created in the same second,
+	// as creation timestamps have a resolution of one second
+	AnnotationTokenIssuedAt = "kid.filariow.github.io/issued-at"
+)
+
+// Options are the defaults applied to the identities and tokens created
+type Options struct {
+	// Labels are set on created service accounts and secrets
+	Labels map[string]string
+	// Annotations are set on created service accounts and secrets
+	Annotations map[string]string
+	// TokenTTL, if set, is recorded on created tokens as their expiration time.
+	// Service account tokens do not expire, so they are not invalidated:
+	// the expiration is only a reminder to rotate them.
+	TokenTTL time.Duration
+	// SecretNamer builds and parses the names of the secrets storing the tokens,
+	// if nil DefaultSecretNameTemplate is used
+	SecretNamer *SecretNamer
+}
+
+var logger = logr.Discard()
+
+// SetLogger sets the logger the package's functions log their steps to,
+// by default nothing is logged.
+// Created and deleted objects are logged at verbosity 1, listed secrets and
+// the selection of the last token at verbosity 2 and 3.
+func SetLogger(l logr.Logger) {
+	logger = l
+}
+
+// GetTokenExpiration returns the time the token stored in the secret expires at,
+// or nil if it has no expiration
+func GetTokenExpiration(secret *corev1.Secret) (*time.Time, error) {
+	a, ok := secret.Annotations[AnnotationTokenExpiresAt]
+	if !ok {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, a)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid expiration '%s' on secret '%s/%s': %v", ErrSecretMalformed, a, secret.Namespace, secret.Name, err)
+	}
+	return &t, nil
+}
+
+func (m *Manager) namer() *SecretNamer {
+	if m.opts.SecretNamer != nil {
+		return m.opts.SecretNamer
+	}
+	return defaultSecretNamer
+}
+
+// secretAnnotations returns the annotations to set on created token secrets
+func (m *Manager) secretAnnotations() map[string]string {
+	aa := make(map[string]string, len(m.opts.Annotations)+2)
+	for k, v := range m.opts.Annotations {
+		aa[k] = v
+	}
+
+	aa[AnnotationTokenIssuedAt] = m.now().UTC().Format(time.RFC3339Nano)
+
+	if m.opts.TokenTTL > 0 {
+		aa[AnnotationTokenExpiresAt] = m.now().Add(m.opts.TokenTTL).UTC().Format(time.RFC3339)
+	}
+	return aa
+}
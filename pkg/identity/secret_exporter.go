@@ -0,0 +1,125 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	SecretScheme = "secret"
+
+	defaultSecretExporterKey = "kubeconfig"
+
+	annotationExportedIdentity     = "kid.filariow.github.io/identity"
+	annotationExportedNamespace    = "kid.filariow.github.io/namespace"
+	annotationExportedTokenVersion = "kid.filariow.github.io/token-version"
+)
+
+func init() {
+	RegisterExporter(SecretScheme, newSecretExporter)
+}
+
+// secretExporter writes the kubeconfig into a Secret, possibly on another
+// cluster than the one the identity lives in
+type secretExporter struct {
+	cli       kubernetes.Interface
+	name      string
+	namespace string
+	key       string
+}
+
+// SecretExporterURL builds the target of a secret exporter.
+// If context is empty, the current one is used.
+func SecretExporterURL(context string, namespace string, name string) string {
+	u := url.URL{Scheme: SecretScheme, Host: namespace, Path: "/" + name}
+	if context != "" {
+		u.RawQuery = url.Values{"context": []string{context}}.Encode()
+	}
+	return u.String()
+}
+
+// newSecretExporter builds an exporter for
+// 'secret://<namespace>/<name>[?context=<context>&key=<key>]'
+func newSecretExporter(_ context.Context, target *url.URL, opts ExporterOptions) (Exporter, error) {
+	if opts.Encode != nil {
+		return nil, fmt.Errorf("encryption is not supported when exporting to a Secret")
+	}
+	if opts.Format != "" {
+		return nil, fmt.Errorf("format '%s' is not supported when exporting to a Secret: the kubeconfig is written", opts.Format)
+	}
+
+	n := strings.Trim(target.Path, "/")
+	if target.Host == "" || n == "" || strings.Contains(n, "/") {
+		return nil, fmt.Errorf("invalid secret target '%s': expected format is '%s://<namespace>/<name>'", target, SecretScheme)
+	}
+
+	q := target.Query()
+	k := q.Get("key")
+	if k == "" {
+		k = defaultSecretExporterKey
+	}
+
+	var (
+		cli *kubernetes.Clientset
+		err error
+	)
+	if c := q.Get("context"); c != "" {
+		cli, err = kube.GetContextClient(c)
+	} else {
+		cli, err = kube.GetCurrentContextClient()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &secretExporter{cli: cli, name: n, namespace: target.Host, key: k}, nil
+}
+
+func (e *secretExporter) Export(ctx context.Context, c *Credentials) error {
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      e.name,
+			Namespace: e.namespace,
+			Annotations: map[string]string{
+				annotationExportedIdentity:     c.Identity,
+				annotationExportedNamespace:    c.Namespace,
+				annotationExportedTokenVersion: strconv.FormatUint(c.Version, 10),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{e.key: c.Kubeconfig},
+	}
+
+	_, err := kube.ApplySecret(ctx, e.cli, s)
+	return err
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNewSecretExporterRejectsOptions(t *testing.T) {
+	tt := map[string]ExporterOptions{
+		"format":     {Format: FormatArgoCDCluster},
+		"encryption": {Encode: func(data []byte) ([]byte, error) { return data, nil }},
+	}
+
+	for n, o := range tt {
+		u, _ := url.Parse(SecretExporterURL("", "ns", "creds"))
+		if _, err := newSecretExporter(context.TODO(), u, o); err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("expected %s to be rejected, found: %v", n, err)
+		}
+	}
+}
+
+func TestNewSecretExporterInvalidTarget(t *testing.T) {
+	for _, tg := range []string{"secret://ns", "secret:///creds", "secret://ns/a/b"} {
+		u, _ := url.Parse(tg)
+		if _, err := newSecretExporter(context.TODO(), u, ExporterOptions{}); err == nil || !strings.Contains(err.Error(), "invalid secret target") {
+			t.Errorf("expected target '%s' to be invalid, found: %v", tg, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type PushFunc func(ctx context.Context, c *Credentials) error
+
+// SyncCredentials pushes the identity's credentials and then pushes them
+// again every time its last token changes, e.g. on rotation or rollback.
+// It returns when the context is done.
+func SyncCredentials(ctx context.Context, cli kubernetes.Interface, name string, namespace string, opts GetKubeconfigOptions, push PushFunc) error {
+	return newDefaultManager(cli).SyncCredentials(ctx, name, namespace, opts, push)
+}
+
+// SyncCredentials pushes the identity's credentials and then pushes them
+// again every time its last token changes, e.g. on rotation or rollback.
+// It returns when the context is done.
+func (m *Manager) SyncCredentials(ctx context.Context, name string, namespace string, opts GetKubeconfigOptions, push PushFunc) error {
+	var lc *Credentials
+	sync := func() error {
+		c, err := m.GetCredentials(ctx, name, namespace, opts)
+		if err != nil {
+			return err
+		}
+
+		if lc != nil && lc.Version == c.Version && bytes.Equal(lc.Token.Token, c.Token.Token) {
+			return nil
+		}
+
+		if err := push(ctx, c); err != nil {
+			return err
+		}
+		lc = c
+		return nil
+	}
+
+	if err := sync(); err != nil {
+		return err
+	}
+
+	for {
+		w, err := m.cli.CoreV1().Secrets(namespace).Watch(ctx, mv1.ListOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for e := range w.ResultChan() {
+			s, ok := e.Object.(*corev1.Secret)
+			if !ok || s.Annotations[corev1.ServiceAccountNameKey] != name {
+				continue
+			}
+
+			// the token controller may not have populated the new secret yet,
+			// it will be synced on the following update
+			if err := sync(); err != nil && !errors.Is(err, ErrSecretMalformed) && !errors.Is(err, kube.ErrSecretNotFound) {
+				w.Stop()
+				return err
+			}
+		}
+		w.Stop()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
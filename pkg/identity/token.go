@@ -28,8 +28,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
-var ErrSecretMalformed = fmt.Errorf("service account's secret malformed")
-
 type ServiceAccountToken struct {
 	CACrt     []byte `json:"ca.crt"`
 	Namespace []byte `json:"namespace"`
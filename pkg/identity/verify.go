@@ -0,0 +1,147 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/filariow/kid/pkg/kube"
+	authzv1 "k8s.io/api/authorization/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	ErrKubeconfigTLSMismatch    = fmt.Errorf("kubeconfig's CA does not match the server certificate")
+	ErrKubeconfigUnreachable    = fmt.Errorf("kubeconfig's server is unreachable")
+	ErrKubeconfigTokenRejected  = fmt.Errorf("kubeconfig's token has been rejected by the server")
+	ErrKubeconfigUnexpectedUser = fmt.Errorf("kubeconfig authenticates an unexpected user")
+)
+
+// VerifyKubeconfig builds a client from the given kubeconfig and checks that
+// the server accepts it and authenticates the identity's service account.
+func VerifyKubeconfig(ctx context.Context, kfg []byte, name string, namespace string) error {
+	cli, err := kube.BuildClient(kfg)
+	if err != nil {
+		return err
+	}
+	return verifyKubeconfigClient(ctx, cli, kfg, name, namespace)
+}
+
+// verifyKubeconfigClient checks that the server accepts the client built from
+// the kubeconfig and authenticates it as the identity's service account.
+func verifyKubeconfigClient(ctx context.Context, cli kubernetes.Interface, kfg []byte, name string, namespace string) error {
+	u, err := getAuthenticatedUser(ctx, cli, kfg)
+	if err != nil {
+		return diagnoseKubeconfigError(err)
+	}
+
+	if eu := ServiceAccountUsername(name, namespace); u != eu {
+		return fmt.Errorf("%w: expected '%s', got '%s'", ErrKubeconfigUnexpectedUser, eu, u)
+	}
+	return nil
+}
+
+func ServiceAccountUsername(name string, namespace string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
+
+// getAuthenticatedUser asks the server who the client is authenticated as.
+// If none of the SelfSubjectReview API versions is served, a
+// SelfSubjectAccessReview is used to check that the token is accepted and
+// the user is read from the token's claims.
+func getAuthenticatedUser(ctx context.Context, cli kubernetes.Interface, kfg []byte) (string, error) {
+	u, err := kube.ReviewSelfSubject(ctx, cli)
+	if err == nil {
+		return u, nil
+	}
+	if !kerrors.IsNotFound(err) {
+		return "", err
+	}
+
+	ar := &authzv1.SelfSubjectAccessReview{
+		Spec: authzv1.SelfSubjectAccessReviewSpec{
+			NonResourceAttributes: &authzv1.NonResourceAttributes{Path: "/version", Verb: "get"},
+		},
+	}
+	if _, err := cli.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, ar, mv1.CreateOptions{}); err != nil {
+		return "", err
+	}
+
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kfg)
+	if err != nil {
+		return "", err
+	}
+	return getTokenSubject([]byte(cfg.BearerToken))
+}
+
+func diagnoseKubeconfigError(err error) error {
+	var (
+		uae x509.UnknownAuthorityError
+		cie x509.CertificateInvalidError
+		he  x509.HostnameError
+		cve *tls.CertificateVerificationError
+		ne  net.Error
+	)
+
+	switch {
+	case errors.As(err, &uae), errors.As(err, &cie), errors.As(err, &he), errors.As(err, &cve):
+		return fmt.Errorf("%w: %w", ErrKubeconfigTLSMismatch, err)
+	case kerrors.IsUnauthorized(err):
+		return fmt.Errorf("%w: %w", ErrKubeconfigTokenRejected, err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne):
+		return fmt.Errorf("%w: %w", ErrKubeconfigUnreachable, err)
+	default:
+		return err
+	}
+}
+
+func getTokenSubject(token []byte) (string, error) {
+	pp := strings.Split(string(token), ".")
+	if len(pp) != 3 {
+		return "", fmt.Errorf("%w: token is not a JWT", ErrSecretMalformed)
+	}
+
+	p, err := base64.RawURLEncoding.DecodeString(pp[1])
+	if err != nil {
+		return "", fmt.Errorf("%w: can not decode token claims: %v", ErrSecretMalformed, err)
+	}
+
+	c := struct {
+		Subject string `json:"sub"`
+	}{}
+	if err := json.Unmarshal(p, &c); err != nil {
+		return "", fmt.Errorf("%w: can not parse token claims: %v", ErrSecretMalformed, err)
+	}
+	return c.Subject, nil
+}
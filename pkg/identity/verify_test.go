@@ -0,0 +1,145 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package identity
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/filariow/kid/pkg/simulator"
+	authnv1 "k8s.io/api/authentication/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// newVerifyTestKubeconfig returns the kubeconfig of a new identity 'app'
+func newVerifyTestKubeconfig(t *testing.T, cli *simulator.Cluster) []byte {
+	t.Helper()
+
+	createTestIdentity(t, cli, "app", 0)
+	s, err := GetLastTokenSecret(context.TODO(), cli, "app", testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error getting the token secret: %v", err)
+	}
+	tkn, err := GetToken(s)
+	if err != nil {
+		t.Fatalf("unexpected error getting the token: %v", err)
+	}
+	h := simulator.ServerURL
+	kfg, err := GetKubeconfig(cli, tkn, GetKubeconfigOptions{OverrideHost: &h})
+	if err != nil {
+		t.Fatalf("unexpected error building the kubeconfig: %v", err)
+	}
+	return kfg
+}
+
+// reviewSelfSubjectAs makes the cluster authenticate clients as the given user,
+// or answer the reviews with err if set
+func reviewSelfSubjectAs(cli *simulator.Cluster, username string, err error) {
+	cli.PrependReactor("create", "selfsubjectreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if err != nil {
+			return true, nil, err
+		}
+		r := action.(ktesting.CreateAction).GetObject().(*authnv1.SelfSubjectReview)
+		r.Status.UserInfo = authnv1.UserInfo{Username: username}
+		return true, r, nil
+	})
+}
+
+func TestVerifyKubeconfigClient(t *testing.T) {
+	cli := newFakeClient()
+	kfg := newVerifyTestKubeconfig(t, cli)
+	reviewSelfSubjectAs(cli, ServiceAccountUsername("app", testNamespace), nil)
+
+	if err := verifyKubeconfigClient(context.TODO(), cli, kfg, "app", testNamespace); err != nil {
+		t.Errorf("unexpected error verifying the kubeconfig: %v", err)
+	}
+}
+
+func TestVerifyKubeconfigClientUnexpectedUser(t *testing.T) {
+	cli := newFakeClient()
+	kfg := newVerifyTestKubeconfig(t, cli)
+
+	err := verifyKubeconfigClient(context.TODO(), cli, kfg, "app", testNamespace)
+	if !errors.Is(err, ErrKubeconfigUnexpectedUser) {
+		t.Errorf("expected error '%v' for the simulator's user, found '%v'", ErrKubeconfigUnexpectedUser, err)
+	}
+}
+
+func TestVerifyKubeconfigClientReviewNotServed(t *testing.T) {
+	cli := newFakeClient()
+	kfg := newVerifyTestKubeconfig(t, cli)
+	reviewSelfSubjectAs(cli, "", kerrors.NewNotFound(authnv1.Resource("selfsubjectreviews"), ""))
+
+	// the user is read from the token once the access review is accepted
+	if err := verifyKubeconfigClient(context.TODO(), cli, kfg, "app", testNamespace); err != nil {
+		t.Errorf("unexpected error verifying the kubeconfig: %v", err)
+	}
+	if err := verifyKubeconfigClient(context.TODO(), cli, kfg, "other", testNamespace); !errors.Is(err, ErrKubeconfigUnexpectedUser) {
+		t.Errorf("expected error '%v' for another identity, found '%v'", ErrKubeconfigUnexpectedUser, err)
+	}
+}
+
+func TestVerifyKubeconfigClientTokenRejected(t *testing.T) {
+	cli := newFakeClient()
+	kfg := newVerifyTestKubeconfig(t, cli)
+	reviewSelfSubjectAs(cli, "", kerrors.NewUnauthorized("invalid bearer token"))
+
+	err := verifyKubeconfigClient(context.TODO(), cli, kfg, "app", testNamespace)
+	if !errors.Is(err, ErrKubeconfigTokenRejected) || !kerrors.IsUnauthorized(err) {
+		t.Errorf("expected error '%v' wrapping the Unauthorized cause, found '%v'", ErrKubeconfigTokenRejected, err)
+	}
+}
+
+func TestDiagnoseKubeconfigError(t *testing.T) {
+	tt := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"unknown authority", x509.UnknownAuthorityError{}, ErrKubeconfigTLSMismatch},
+		{"hostname", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "kube"}, ErrKubeconfigTLSMismatch},
+		{"unauthorized", kerrors.NewUnauthorized("invalid bearer token"), ErrKubeconfigTokenRejected},
+		{"deadline", context.DeadlineExceeded, ErrKubeconfigUnreachable},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrKubeconfigUnreachable},
+	}
+
+	for _, tc := range tt {
+		err := diagnoseKubeconfigError(tc.err)
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("%s: expected error '%v', found '%v'", tc.name, tc.expected, err)
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected the cause '%v' to be wrapped, found '%v'", tc.name, tc.err, err)
+		}
+	}
+
+	err := errors.New("other")
+	if d := diagnoseKubeconfigError(err); d != err {
+		t.Errorf("expected other errors to be returned as is, found '%v'", d)
+	}
+}
@@ -23,14 +23,55 @@ THE SOFTWARE.
 package kube
 
 import (
+	"fmt"
 	"os"
-	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// ConfigOptions selects the kubeconfig and overrides its values,
+// as kubectl's global flags do
+type ConfigOptions struct {
+	// Kubeconfig is the path of the kubeconfig file, if empty the standard
+	// loading rules apply: the KUBECONFIG list of files, or $HOME/.kube/config
+	Kubeconfig  string
+	Context     string
+	Cluster     string
+	User        string
+	Server      string
+	Impersonate string
+	// ImpersonateGroups and ImpersonateUID complete the impersonated user
+	ImpersonateGroups []string
+	ImpersonateUID    string
+	// Timeout is the timeout of each request, as a duration or a number of
+	// seconds: "0" or empty means no timeout
+	Timeout string
+	// InCluster forces the use of the in-cluster configuration, i.e. the
+	// service account mounted in the Pod kid is running in
+	InCluster bool
+	// Loader, if set, loads the kubeconfig instead of the loading rules and
+	// overrides built from the other fields, e.g. to honor kubectl's flags
+	// with genericclioptions.ConfigFlags' ToRawKubeConfigLoader
+	Loader clientcmd.ClientConfig
+}
+
+const (
+	inClusterTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+var configOptions ConfigOptions
+
+// SetConfigOptions sets the options used to build clients and REST configs
+func SetConfigOptions(o ConfigOptions) {
+	configOptions = o
+}
+
 func GetCurrentContextClient() (*kubernetes.Clientset, error) {
 	cfg, err := GetRESTConfig()
 	if err != nil {
@@ -40,12 +81,59 @@ func GetCurrentContextClient() (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(cfg)
 }
 
-func GetConfigDefaultNamespace() (*string, error) {
-	cc, err := getClientConfig()
+// GetContextClient builds a client for the given context of the kubeconfig.
+// Overrides set with SetConfigOptions, other than the kubeconfig path, are not applied.
+func GetContextClient(context string) (*kubernetes.Clientset, error) {
+	o := ConfigOptions{Kubeconfig: configOptions.Kubeconfig, Context: context}
+	cfg, err := newClientConfig(o).ClientConfig()
 	if err != nil {
 		return nil, err
 	}
-	ns, _, err := cc.Namespace()
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+// IsInCluster returns true if the in-cluster configuration is in use,
+// either because it is forced or because no kubeconfig is found
+func IsInCluster() bool {
+	if configOptions.InCluster {
+		return true
+	}
+
+	rc, err := getClientConfig().RawConfig()
+	return (err != nil || len(rc.Contexts) == 0) && isInClusterPossible()
+}
+
+// GetCurrentContextNames returns the names of the kubeconfig context and cluster
+// in use. They are empty if the in-cluster configuration is used.
+func GetCurrentContextNames() (context string, cluster string, err error) {
+	if configOptions.InCluster {
+		return "", "", nil
+	}
+
+	rc, err := getClientConfig().RawConfig()
+	if err != nil {
+		return "", "", err
+	}
+
+	context = configOptions.Context
+	if context == "" {
+		context = rc.CurrentContext
+	}
+
+	cluster = configOptions.Cluster
+	if c, ok := rc.Contexts[context]; ok && cluster == "" {
+		cluster = c.Cluster
+	}
+	return context, cluster, nil
+}
+
+func GetConfigDefaultNamespace() (*string, error) {
+	if configOptions.InCluster {
+		return getInClusterNamespace()
+	}
+
+	ns, _, err := getClientConfig().Namespace()
 	if err != nil {
 		return nil, err
 	}
@@ -54,12 +142,11 @@ func GetConfigDefaultNamespace() (*string, error) {
 }
 
 func GetRESTConfig() (*rest.Config, error) {
-	cc, err := getClientConfig()
-	if err != nil {
-		return nil, err
+	if configOptions.InCluster {
+		return getInClusterRESTConfig()
 	}
 
-	cfg, err := cc.ClientConfig()
+	cfg, err := getClientConfig().ClientConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -67,32 +154,91 @@ func GetRESTConfig() (*rest.Config, error) {
 	return cfg, nil
 }
 
-func getClientConfig() (clientcmd.ClientConfig, error) {
-	kp, err := getKubeconfigPath()
+func getInClusterRESTConfig() (*rest.Config, error) {
+	cfg, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	kd, err := os.ReadFile(*kp)
-	if err != nil {
+	if err := applyInClusterOverrides(cfg, configOptions); err != nil {
 		return nil, err
 	}
+	return cfg, nil
+}
 
-	return clientcmd.NewClientConfigFromBytes(kd)
+// applyInClusterOverrides sets on the in-cluster configuration the
+// options that do not select a kubeconfig entry
+func applyInClusterOverrides(cfg *rest.Config, o ConfigOptions) error {
+	if o.Server != "" {
+		cfg.Host = o.Server
+	}
+	cfg.Impersonate = rest.ImpersonationConfig{
+		UserName: o.Impersonate,
+		Groups:   o.ImpersonateGroups,
+		UID:      o.ImpersonateUID,
+	}
+
+	t, err := parseTimeout(o.Timeout)
+	if err != nil {
+		return err
+	}
+	cfg.Timeout = t
+	return nil
 }
 
-func getKubeconfigPath() (*string, error) {
-	if env := os.Getenv("KUBECONFIG"); env != "" {
-		return &env, nil
+// parseTimeout parses a request timeout as kubectl's --request-timeout flag does:
+// a number of seconds or a duration with its unit
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	if s, err := strconv.ParseInt(timeout, 10, 64); err == nil {
+		return time.Duration(s) * time.Second, nil
+	}
+
+	t, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request timeout '%s': it must be a number of seconds or a duration with its unit (e.g. 1s, 2m, 3h)", timeout)
 	}
+	return t, nil
+}
 
-	hd, err := os.UserHomeDir()
+func getInClusterNamespace() (*string, error) {
+	d, err := os.ReadFile(inClusterNamespaceFile)
 	if err != nil {
 		return nil, err
 	}
 
-	kp := path.Join(hd, clientcmd.RecommendedHomeDir, clientcmd.RecommendedFileName)
-	return &kp, nil
+	ns := strings.TrimSpace(string(d))
+	return &ns, nil
+}
+
+func isInClusterPossible() bool {
+	_, err := os.Stat(inClusterTokenFile)
+	return err == nil && os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
+func getClientConfig() clientcmd.ClientConfig {
+	if configOptions.Loader != nil {
+		return configOptions.Loader
+	}
+	return newClientConfig(configOptions)
+}
+
+func newClientConfig(o ConfigOptions) clientcmd.ClientConfig {
+	lr := clientcmd.NewDefaultClientConfigLoadingRules()
+	lr.ExplicitPath = o.Kubeconfig
+
+	co := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
+	co.Context.Cluster = o.Cluster
+	co.Context.AuthInfo = o.User
+	co.ClusterInfo.Server = o.Server
+	co.AuthInfo.Impersonate = o.Impersonate
+	co.AuthInfo.ImpersonateGroups = o.ImpersonateGroups
+	co.AuthInfo.ImpersonateUID = o.ImpersonateUID
+	co.Timeout = o.Timeout
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(lr, co)
 }
 
 func BuildClient(kfg []byte) (*kubernetes.Clientset, error) {
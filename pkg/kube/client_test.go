@@ -0,0 +1,79 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestApplyInClusterOverrides(t *testing.T) {
+	cfg := &rest.Config{Host: "https://10.0.0.1:443"}
+	o := ConfigOptions{
+		Server:            "https://kube.example.com",
+		Impersonate:       "alice",
+		ImpersonateGroups: []string{"ops", "dev"},
+		ImpersonateUID:    "uid",
+		Timeout:           "30s",
+	}
+
+	if err := applyInClusterOverrides(cfg, o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Host != o.Server {
+		t.Errorf("expected host '%s', found '%s'", o.Server, cfg.Host)
+	}
+	if i := cfg.Impersonate; i.UserName != "alice" || len(i.Groups) != 2 || i.Groups[1] != "dev" || i.UID != "uid" {
+		t.Errorf("expected the impersonation options to be applied, found %+v", i)
+	}
+	if cfg.Timeout != 30*time.Second {
+		t.Errorf("expected timeout 30s, found %v", cfg.Timeout)
+	}
+
+	if err := applyInClusterOverrides(cfg, ConfigOptions{Timeout: "soon"}); err == nil {
+		t.Errorf("expected an invalid timeout to be rejected")
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tt := map[string]time.Duration{
+		"":     0,
+		"0":    0,
+		"5":    5 * time.Second,
+		"2m":   2 * time.Minute,
+		"1h5s": time.Hour + 5*time.Second,
+	}
+
+	for s, e := range tt {
+		d, err := parseTimeout(s)
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %v", s, err)
+			continue
+		}
+		if d != e {
+			t.Errorf("expected '%s' to be parsed as %v, found %v", s, e, d)
+		}
+	}
+}
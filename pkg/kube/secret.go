@@ -27,6 +27,7 @@ import (
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -34,49 +35,58 @@ import (
 
 var ErrSecretNotFound = fmt.Errorf("service account's secret not found")
 
-func GetLastServiceAccountSecrets(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) (*corev1.Secret, error) {
-	ss, err := GetServiceAccountSecrets(ctx, cli, name, namespace)
+func GetServiceAccountSecrets(ctx context.Context, cli kubernetes.Interface, name string, namespace string) ([]corev1.Secret, error) {
+	ss, err := cli.CoreV1().Secrets(namespace).List(ctx, mv1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	if len(ss) == 0 {
-		return nil, ErrSecretNotFound
-	}
-
-	fs := ss[0]
-	for _, s := range ss[1:] {
-		if s.GetCreationTimestamp().Compare(fs.GetCreationTimestamp().Time) >= 0 {
-			fs = s
+	fss := []corev1.Secret{}
+	for _, s := range ss.Items {
+		if n, ok := s.Annotations[corev1.ServiceAccountNameKey]; ok && n == name {
+			fss = append(fss, s)
 		}
 	}
-
-	return &fs, nil
+	return fss, nil
 }
 
-func GetServiceAccountSecrets(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) ([]corev1.Secret, error) {
+// GroupServiceAccountSecrets returns the secrets in the namespace
+// grouped by the name of the service account they are annotated with
+func GroupServiceAccountSecrets(ctx context.Context, cli kubernetes.Interface, namespace string) (map[string][]corev1.Secret, error) {
 	ss, err := cli.CoreV1().Secrets(namespace).List(ctx, mv1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	fss := []corev1.Secret{}
+	gss := map[string][]corev1.Secret{}
 	for _, s := range ss.Items {
-		if n, ok := s.Annotations[corev1.ServiceAccountNameKey]; ok && n == name {
-			fss = append(fss, s)
+		if n, ok := s.Annotations[corev1.ServiceAccountNameKey]; ok {
+			gss[n] = append(gss[n], s)
 		}
 	}
-	return fss, nil
+	return gss, nil
 }
 
-func CreateServiceAccountSecret(ctx context.Context, cli kubernetes.Clientset, name string, namespace string, sa *corev1.ServiceAccount) (*corev1.Secret, error) {
+// ListServiceAccountTokenSecrets returns the service account token secrets in the namespace
+func ListServiceAccountTokenSecrets(ctx context.Context, cli kubernetes.Interface, namespace string) ([]corev1.Secret, error) {
+	o := mv1.ListOptions{FieldSelector: "type=" + string(corev1.SecretTypeServiceAccountToken)}
+	ss, err := cli.CoreV1().Secrets(namespace).List(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss.Items, nil
+}
+
+func CreateServiceAccountSecret(ctx context.Context, cli kubernetes.Interface, name string, namespace string, sa *corev1.ServiceAccount, labels map[string]string, annotations map[string]string) (*corev1.Secret, error) {
 	s := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
-			Annotations: map[string]string{
+			Labels:    labels,
+			Annotations: MergeStringMaps(MergeStringMaps(nil, annotations), map[string]string{
 				corev1.ServiceAccountNameKey: sa.Name,
-			},
+			}),
 			OwnerReferences: []metav1.OwnerReference{
 				{
 					APIVersion: "v1",
@@ -92,6 +102,41 @@ func CreateServiceAccountSecret(ctx context.Context, cli kubernetes.Clientset, n
 	return cli.CoreV1().Secrets(namespace).Create(ctx, s, mv1.CreateOptions{})
 }
 
-func DeleteServiceAccountSecret(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) error {
+func DeleteServiceAccountSecret(ctx context.Context, cli kubernetes.Interface, name string, namespace string) error {
 	return cli.CoreV1().Secrets(namespace).Delete(ctx, name, mv1.DeleteOptions{})
 }
+
+// ApplySecret creates the secret or, if it already exists, sets on it the
+// labels, annotations and data keys of the given one
+func ApplySecret(ctx context.Context, cli kubernetes.Interface, secret *corev1.Secret) (*corev1.Secret, error) {
+	sc := cli.CoreV1().Secrets(secret.Namespace)
+	s, err := sc.Get(ctx, secret.Name, mv1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return sc.Create(ctx, secret, mv1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.Labels = MergeStringMaps(s.Labels, secret.Labels)
+	s.Annotations = MergeStringMaps(s.Annotations, secret.Annotations)
+	if s.Data == nil {
+		s.Data = make(map[string][]byte, len(secret.Data))
+	}
+	for k, v := range secret.Data {
+		s.Data[k] = v
+	}
+	return sc.Update(ctx, s, mv1.UpdateOptions{})
+}
+
+// MergeStringMaps copies the entries of src into dst, overriding the ones
+// with the same key, and returns dst. A nil dst is allocated when src is not empty.
+func MergeStringMaps(dst map[string]string, src map[string]string) map[string]string {
+	if dst == nil && len(src) > 0 {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetServiceAccountSecrets(t *testing.T) {
+	now := time.Now()
+	secret := func(name, sa string, age time.Duration) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: mv1.ObjectMeta{
+				Name:              name,
+				Namespace:         "test",
+				Annotations:       map[string]string{corev1.ServiceAccountNameKey: sa},
+				CreationTimestamp: mv1.NewTime(now.Add(-age)),
+			},
+		}
+	}
+	cli := fake.NewSimpleClientset(
+		secret("app-key-0", "app", 3*time.Hour),
+		secret("app-key-2", "app", time.Hour),
+		secret("app-key-1", "app", 2*time.Hour),
+		secret("other-key-0", "other", 0),
+	)
+
+	ss, err := GetServiceAccountSecrets(context.TODO(), cli, "app", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ss) != 3 {
+		t.Errorf("expected the 3 secrets of 'app', found %d", len(ss))
+	}
+	for _, s := range ss {
+		if s.Annotations[corev1.ServiceAccountNameKey] != "app" {
+			t.Errorf("expected only secrets of 'app', found '%s'", s.Name)
+		}
+	}
+
+	if ss, err := GetServiceAccountSecrets(context.TODO(), cli, "missing", "test"); err != nil || len(ss) != 0 {
+		t.Errorf("expected no secrets, found %v and error %v", ss, err)
+	}
+}
+
+func TestCreateServiceAccountSecret(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+	sa := &corev1.ServiceAccount{ObjectMeta: mv1.ObjectMeta{Name: "app", Namespace: "test", UID: "uid"}}
+
+	s, err := CreateServiceAccountSecret(context.TODO(), cli, "app-key-0", "test", sa, nil,
+		map[string]string{"custom": "value", corev1.ServiceAccountNameKey: "other"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.Type != corev1.SecretTypeServiceAccountToken {
+		t.Errorf("expected secret type '%s', found '%s'", corev1.SecretTypeServiceAccountToken, s.Type)
+	}
+	if s.Annotations[corev1.ServiceAccountNameKey] != "app" || s.Annotations["custom"] != "value" {
+		t.Errorf("unexpected annotations: %v", s.Annotations)
+	}
+	if len(s.OwnerReferences) != 1 || s.OwnerReferences[0].UID != "uid" {
+		t.Errorf("expected the service account as owner, found %v", s.OwnerReferences)
+	}
+}
+
+func TestApplySecret(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+	s := &corev1.Secret{
+		ObjectMeta: mv1.ObjectMeta{
+			Name:      "dest",
+			Namespace: "test",
+			Labels:    map[string]string{"a": "1"},
+		},
+		Data: map[string][]byte{"token": []byte("t1"), "other": []byte("o")},
+	}
+	if _, err := ApplySecret(context.TODO(), cli, s); err != nil {
+		t.Fatalf("unexpected error creating secret: %v", err)
+	}
+
+	u := &corev1.Secret{
+		ObjectMeta: mv1.ObjectMeta{
+			Name:      "dest",
+			Namespace: "test",
+			Labels:    map[string]string{"b": "2"},
+		},
+		Data: map[string][]byte{"token": []byte("t2")},
+	}
+	r, err := ApplySecret(context.TODO(), cli, u)
+	if err != nil {
+		t.Fatalf("unexpected error updating secret: %v", err)
+	}
+
+	if r.Labels["a"] != "1" || r.Labels["b"] != "2" {
+		t.Errorf("expected labels to be merged, found %v", r.Labels)
+	}
+	if string(r.Data["token"]) != "t2" || string(r.Data["other"]) != "o" {
+		t.Errorf("expected data to be merged, found %v", r.Data)
+	}
+}
@@ -31,14 +31,26 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-func CreateServiceAccount(ctx context.Context, cli kubernetes.Clientset, name string, namespace string) (*corev1.ServiceAccount, error) {
+func CreateServiceAccount(ctx context.Context, cli kubernetes.Interface, name string, namespace string, labels map[string]string, annotations map[string]string) (*corev1.ServiceAccount, error) {
 	c := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 	}
 
 	o := mv1.CreateOptions{}
 	return cli.CoreV1().ServiceAccounts(namespace).Create(ctx, c, o)
 }
+
+func ListServiceAccounts(ctx context.Context, cli kubernetes.Interface, namespace string, selector string) ([]corev1.ServiceAccount, error) {
+	o := mv1.ListOptions{LabelSelector: selector}
+	ss, err := cli.CoreV1().ServiceAccounts(namespace).List(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss.Items, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authnv1alpha1 "k8s.io/api/authentication/v1alpha1"
+	authnv1beta1 "k8s.io/api/authentication/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// UnknownUsername is the name of the user when the cluster can not tell who
+// the client is authenticated as
+const UnknownUsername = "<unknown>"
+
+// GetUsername returns the name of the user the client is authenticated as,
+// as returned by the SelfSubjectReview API, or UnknownUsername if the
+// cluster can not tell it.
+func GetUsername(ctx context.Context, cli kubernetes.Interface) string {
+	u, err := ReviewSelfSubject(ctx, cli)
+	if err != nil {
+		return UnknownUsername
+	}
+	return u
+}
+
+// ReviewSelfSubject asks the cluster who the client is authenticated as with
+// the SelfSubjectReview API, trying its v1, v1beta1 and v1alpha1 versions in
+// order. It returns a NotFound error if none of them is served.
+func ReviewSelfSubject(ctx context.Context, cli kubernetes.Interface) (string, error) {
+	var err error
+	for _, review := range []func(context.Context, kubernetes.Interface) (authnv1.UserInfo, error){
+		reviewSelfSubjectV1,
+		reviewSelfSubjectV1beta1,
+		reviewSelfSubjectV1alpha1,
+	} {
+		var ui authnv1.UserInfo
+		ui, err = review(ctx, cli)
+		switch {
+		case kerrors.IsNotFound(err):
+			continue
+		case err != nil:
+			return "", err
+		case ui.Username == "":
+			return "", fmt.Errorf("self subject review returned no username")
+		default:
+			return ui.Username, nil
+		}
+	}
+	return "", err
+}
+
+func reviewSelfSubjectV1(ctx context.Context, cli kubernetes.Interface) (authnv1.UserInfo, error) {
+	r, err := cli.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authnv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return authnv1.UserInfo{}, err
+	}
+	return r.Status.UserInfo, nil
+}
+
+func reviewSelfSubjectV1beta1(ctx context.Context, cli kubernetes.Interface) (authnv1.UserInfo, error) {
+	r, err := cli.AuthenticationV1beta1().SelfSubjectReviews().Create(ctx, &authnv1beta1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return authnv1.UserInfo{}, err
+	}
+	return r.Status.UserInfo, nil
+}
+
+func reviewSelfSubjectV1alpha1(ctx context.Context, cli kubernetes.Interface) (authnv1.UserInfo, error) {
+	r, err := cli.AuthenticationV1alpha1().SelfSubjectReviews().Create(ctx, &authnv1alpha1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return authnv1.UserInfo{}, err
+	}
+	return r.Status.UserInfo, nil
+}
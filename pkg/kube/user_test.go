@@ -0,0 +1,98 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authnv1alpha1 "k8s.io/api/authentication/v1alpha1"
+	authnv1beta1 "k8s.io/api/authentication/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// newReviewingClient returns a client whose cluster serves the
+// SelfSubjectReview API only in the given versions
+func newReviewingClient(versions ...string) *fake.Clientset {
+	cli := fake.NewSimpleClientset()
+	cli.PrependReactor("create", "selfsubjectreviews", func(action ktesting.Action) (bool, runtime.Object, error) {
+		v := action.GetResource().Version
+		served := false
+		for _, sv := range versions {
+			served = served || sv == v
+		}
+		if !served {
+			return true, nil, kerrors.NewNotFound(action.GetResource().GroupResource(), "")
+		}
+
+		ui := authnv1.UserInfo{Username: "alice@" + v}
+		switch r := action.(ktesting.CreateAction).GetObject().(type) {
+		case *authnv1.SelfSubjectReview:
+			r.Status.UserInfo = ui
+			return true, r, nil
+		case *authnv1beta1.SelfSubjectReview:
+			r.Status.UserInfo = ui
+			return true, r, nil
+		case *authnv1alpha1.SelfSubjectReview:
+			r.Status.UserInfo = ui
+			return true, r, nil
+		}
+		return false, nil, nil
+	})
+	return cli
+}
+
+func TestReviewSelfSubject(t *testing.T) {
+	tt := []struct {
+		versions []string
+		expected string
+	}{
+		{[]string{"v1", "v1beta1", "v1alpha1"}, "alice@v1"},
+		{[]string{"v1beta1", "v1alpha1"}, "alice@v1beta1"},
+		{[]string{"v1alpha1"}, "alice@v1alpha1"},
+	}
+
+	for _, tc := range tt {
+		u, err := ReviewSelfSubject(context.TODO(), newReviewingClient(tc.versions...))
+		if err != nil {
+			t.Errorf("unexpected error reviewing with versions %v: %v", tc.versions, err)
+			continue
+		}
+		if u != tc.expected {
+			t.Errorf("expected user '%s' with versions %v, found '%s'", tc.expected, tc.versions, u)
+		}
+	}
+}
+
+func TestReviewSelfSubjectNotServed(t *testing.T) {
+	if _, err := ReviewSelfSubject(context.TODO(), newReviewingClient()); !kerrors.IsNotFound(err) {
+		t.Errorf("expected a NotFound error, found %v", err)
+	}
+	if u := GetUsername(context.TODO(), newReviewingClient()); u != UnknownUsername {
+		t.Errorf("expected user '%s', found '%s'", UnknownUsername, u)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package sealedsecret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"github.com/filariow/kid/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ScopeStrict        = "strict"
+	ScopeNamespaceWide = "namespace-wide"
+	ScopeClusterWide   = "cluster-wide"
+
+	annotationNamespaceWide = "sealedsecrets.bitnami.com/namespace-wide"
+	annotationClusterWide   = "sealedsecrets.bitnami.com/cluster-wide"
+
+	sessionKeyBytes = 32
+)
+
+var Scopes = []string{ScopeStrict, ScopeNamespaceWide, ScopeClusterWide}
+
+var ErrInvalidCert = fmt.Errorf("invalid sealed-secrets certificate")
+
+type SealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec SealedSecretSpec `json:"spec"`
+}
+
+type SealedSecretSpec struct {
+	Template      SecretTemplateSpec `json:"template"`
+	EncryptedData map[string]string  `json:"encryptedData"`
+}
+
+type SecretTemplateSpec struct {
+	metav1.ObjectMeta `json:"metadata"`
+
+	Type corev1.SecretType `json:"type,omitempty"`
+}
+
+// ParsePublicKey parses the controller's public key from a PEM encoded
+// certificate, as returned by 'kubeseal --fetch-cert', or public key
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", ErrInvalidCert)
+	}
+
+	var (
+		k   interface{}
+		err error
+	)
+	switch b.Type {
+	case "CERTIFICATE":
+		var c *x509.Certificate
+		if c, err = x509.ParseCertificate(b.Bytes); err == nil {
+			k = c.PublicKey
+		}
+	case "PUBLIC KEY":
+		k, err = x509.ParsePKIXPublicKey(b.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unexpected PEM block '%s'", ErrInvalidCert, b.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCert, err)
+	}
+
+	rk, ok := k.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not RSA", ErrInvalidCert)
+	}
+	return rk, nil
+}
+
+// Seal builds a SealedSecret for the given Secret, encrypting each data key
+// with the controller's public key
+func Seal(pubKey *rsa.PublicKey, scope string, secret *corev1.Secret) (*SealedSecret, error) {
+	l, err := label(scope, secret.Namespace, secret.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		c, err := HybridEncrypt(rand.Reader, pubKey, v, l)
+		if err != nil {
+			return nil, err
+		}
+		ed[k] = base64.StdEncoding.EncodeToString(c)
+	}
+
+	m := metav1.ObjectMeta{
+		Name:        secret.Name,
+		Namespace:   secret.Namespace,
+		Annotations: scopeAnnotations(scope),
+	}
+	tm := *secret.ObjectMeta.DeepCopy()
+	tm.Annotations = kube.MergeStringMaps(tm.Annotations, m.Annotations)
+
+	return &SealedSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "bitnami.com/v1alpha1",
+			Kind:       "SealedSecret",
+		},
+		ObjectMeta: m,
+		Spec: SealedSecretSpec{
+			Template: SecretTemplateSpec{
+				ObjectMeta: tm,
+				Type:       secret.Type,
+			},
+			EncryptedData: ed,
+		},
+	}, nil
+}
+
+// HybridEncrypt encrypts the plaintext as the sealed-secrets controller
+// expects: a random AES-256-GCM session key is encrypted with RSA-OAEP
+// (SHA-256) using the given label, and prepended with its length to the
+// AES-GCM ciphertext of the plaintext
+func HybridEncrypt(rnd io.Reader, pubKey *rsa.PublicKey, plaintext []byte, label []byte) ([]byte, error) {
+	sk := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sk); err != nil {
+		return nil, err
+	}
+
+	b, err := aes.NewCipher(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	aed, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := rsa.EncryptOAEP(sha256.New(), rnd, pubKey, sk, label)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make([]byte, 2, 2+len(rc)+len(plaintext)+aed.Overhead())
+	binary.BigEndian.PutUint16(c, uint16(len(rc)))
+	c = append(c, rc...)
+
+	// the session key is used only once, so a zero nonce is safe
+	zn := make([]byte, aed.NonceSize())
+	return aed.Seal(c, zn, plaintext, nil), nil
+}
+
+func label(scope string, namespace string, name string) ([]byte, error) {
+	switch scope {
+	case ScopeStrict, "":
+		return []byte(namespace + "/" + name), nil
+	case ScopeNamespaceWide:
+		return []byte(namespace), nil
+	case ScopeClusterWide:
+		return []byte{}, nil
+	default:
+		return nil, fmt.Errorf("invalid sealed-secret scope '%s'", scope)
+	}
+}
+
+func scopeAnnotations(scope string) map[string]string {
+	switch scope {
+	case ScopeNamespaceWide:
+		return map[string]string{annotationNamespaceWide: "true"}
+	case ScopeClusterWide:
+		return map[string]string{annotationClusterWide: "true"}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package sealedsecret
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %v", err)
+	}
+	return k
+}
+
+// hybridDecrypt decrypts the ciphertext as the sealed-secrets controller does
+func hybridDecrypt(key *rsa.PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+	if len(ciphertext) < 2 {
+		return nil, errors.New("ciphertext too short")
+	}
+	l := int(binary.BigEndian.Uint16(ciphertext))
+	if len(ciphertext) < 2+l {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	sk, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+l], label)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := aes.NewCipher(sk)
+	if err != nil {
+		return nil, err
+	}
+	aed, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	return aed.Open(nil, make([]byte, aed.NonceSize()), ciphertext[2+l:], nil)
+}
+
+func TestHybridEncrypt(t *testing.T) {
+	k := newTestKey(t)
+	p := []byte("kubeconfig")
+
+	c, err := HybridEncrypt(rand.Reader, &k.PublicKey, p, []byte("test-ns/app"))
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+
+	d, err := hybridDecrypt(k, c, []byte("test-ns/app"))
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if !bytes.Equal(d, p) {
+		t.Errorf("expected plaintext '%s', found '%s'", p, d)
+	}
+
+	if _, err := hybridDecrypt(k, c, []byte("other-ns/app")); err == nil {
+		t.Errorf("expected decryption with a different label to fail")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	k := newTestKey(t)
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "test-ns",
+			Labels:      map[string]string{"team": "a"},
+			Annotations: map[string]string{"owner": "alice"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"kubeconfig": []byte("kubeconfig"), "token": []byte("token")},
+	}
+
+	tt := []struct {
+		scope       string
+		label       string
+		annotations map[string]string
+	}{
+		{scope: ScopeStrict, label: "test-ns/app"},
+		{scope: ScopeNamespaceWide, label: "test-ns", annotations: map[string]string{annotationNamespaceWide: "true"}},
+		{scope: ScopeClusterWide, label: "", annotations: map[string]string{annotationClusterWide: "true"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.scope, func(t *testing.T) {
+			ss, err := Seal(&k.PublicKey, tc.scope, s)
+			if err != nil {
+				t.Fatalf("unexpected error sealing: %v", err)
+			}
+
+			if ss.Kind != "SealedSecret" || ss.APIVersion != "bitnami.com/v1alpha1" {
+				t.Errorf("expected a bitnami.com/v1alpha1 SealedSecret, found %s %s", ss.APIVersion, ss.Kind)
+			}
+			if ss.Name != s.Name || ss.Namespace != s.Namespace {
+				t.Errorf("expected SealedSecret '%s/%s', found '%s/%s'", s.Namespace, s.Name, ss.Namespace, ss.Name)
+			}
+			assertAnnotations(t, "SealedSecret", ss.Annotations, tc.annotations)
+
+			ta := map[string]string{"owner": "alice"}
+			for k, v := range tc.annotations {
+				ta[k] = v
+			}
+			assertAnnotations(t, "template", ss.Spec.Template.Annotations, ta)
+			if ss.Spec.Template.Labels["team"] != "a" || ss.Spec.Template.Type != corev1.SecretTypeOpaque {
+				t.Errorf("expected the template to keep the Secret's labels and type, found %+v", ss.Spec.Template)
+			}
+			if s.Annotations[annotationNamespaceWide] != "" || s.Annotations[annotationClusterWide] != "" {
+				t.Errorf("expected the Secret not to be changed, found annotations %v", s.Annotations)
+			}
+
+			if len(ss.Spec.EncryptedData) != len(s.Data) {
+				t.Fatalf("expected %d encrypted keys, found %d", len(s.Data), len(ss.Spec.EncryptedData))
+			}
+			for key, v := range s.Data {
+				c, err := base64.StdEncoding.DecodeString(ss.Spec.EncryptedData[key])
+				if err != nil {
+					t.Fatalf("unexpected error decoding key '%s': %v", key, err)
+				}
+				d, err := hybridDecrypt(k, c, []byte(tc.label))
+				if err != nil {
+					t.Fatalf("unexpected error decrypting key '%s' with label '%s': %v", key, tc.label, err)
+				}
+				if !bytes.Equal(d, v) {
+					t.Errorf("expected key '%s' to be '%s', found '%s'", key, v, d)
+				}
+			}
+		})
+	}
+}
+
+func TestSealInvalidScope(t *testing.T) {
+	k := newTestKey(t)
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test-ns"}}
+
+	if _, err := Seal(&k.PublicKey, "global", s); err == nil {
+		t.Errorf("expected error sealing with an invalid scope")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	k := newTestKey(t)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	c, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("unexpected error creating certificate: %v", err)
+	}
+	pk, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling public key: %v", err)
+	}
+
+	tt := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{name: "certificate", data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c}), valid: true},
+		{name: "public key", data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pk}), valid: true},
+		{name: "no PEM data", data: []byte("not a certificate")},
+		{name: "unexpected block", data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pk})},
+		{name: "invalid certificate", data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")})},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePublicKey(tc.data)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidCert) {
+					t.Errorf("expected error %v, found: %v", ErrInvalidCert, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !p.Equal(&k.PublicKey) {
+				t.Errorf("expected the parsed key to match the generated one")
+			}
+		})
+	}
+}
+
+func assertAnnotations(t *testing.T, name string, found map[string]string, expected map[string]string) {
+	t.Helper()
+
+	if len(found) != len(expected) {
+		t.Errorf("expected %s annotations %v, found %v", name, expected, found)
+		return
+	}
+	for k, v := range expected {
+		if found[k] != v {
+			t.Errorf("expected %s annotation '%s' to be '%s', found '%s'", name, k, v, found[k])
+		}
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package simulator provides a fake Kubernetes client that behaves like a
+// cluster running the token controller: service account token secrets are
+// populated with a signed JWT, the CA certificate and the namespace as soon
+// as they are created. It allows to run kid offline, in tests and with the
+// CLI's --simulate flag.
+package simulator
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authnv1alpha1 "k8s.io/api/authentication/v1alpha1"
+	authnv1beta1 "k8s.io/api/authentication/v1beta1"
+	authzv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// ServerURL is the address of the simulated API server
+const ServerURL = "https://kid.simulator.local:6443"
+
+// Username is the user clients of the simulated cluster are authenticated as
+const Username = "kid-simulator:admin"
+
+// Options configures a simulated cluster
+type Options struct {
+	// Clock returns the current time, it defaults to time.Now
+	Clock func() time.Time
+	// SigningKey signs the tokens and the CA certificate, if not set a new key is generated
+	SigningKey *rsa.PrivateKey
+}
+
+// Cluster is a fake clientset with a simulated token controller
+type Cluster struct {
+	*fake.Clientset
+
+	clock func() time.Time
+	key   *rsa.PrivateKey
+	caCrt []byte
+	last  time.Time
+}
+
+// NewCluster returns a simulated cluster storing the given objects
+func NewCluster(opts Options, objects ...runtime.Object) (*Cluster, error) {
+	c := &Cluster{
+		Clientset: fake.NewSimpleClientset(objects...),
+		clock:     opts.Clock,
+		key:       opts.SigningKey,
+	}
+	if c.clock == nil {
+		c.clock = time.Now
+	}
+	if c.key == nil {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		c.key = k
+	}
+
+	ca, err := newCACertificate(c.key)
+	if err != nil {
+		return nil, err
+	}
+	c.caCrt = ca
+
+	// creation timestamps of new objects must follow the ones of the stored
+	// objects, as the last token version is selected by creation timestamp
+	for _, o := range objects {
+		if m, err := meta.Accessor(o); err == nil && m.GetCreationTimestamp().After(c.last) {
+			c.last = m.GetCreationTimestamp().Time
+		}
+	}
+
+	c.PrependReactor("create", "*", c.createObject)
+	c.PrependReactor("list", "secrets", c.listSecrets)
+	c.PrependReactor("create", "selfsubjectreviews", reviewSelfSubject)
+	c.PrependReactor("create", "selfsubjectaccessreviews", reviewSelfSubjectAccess)
+	return c, nil
+}
+
+// CACrt returns the PEM encoded CA certificate set in the token secrets
+func (c *Cluster) CACrt() []byte {
+	return c.caCrt
+}
+
+// SigningKey returns the key signing the tokens
+func (c *Cluster) SigningKey() *rsa.PrivateKey {
+	return c.key
+}
+
+// createObject sets creation timestamp and UID on the created object and, if
+// it is a service account token secret, populates it like the token controller
+func (c *Cluster) createObject(action ktesting.Action) (bool, runtime.Object, error) {
+	a := action.(ktesting.CreateAction)
+	o := a.GetObject().DeepCopyObject()
+	m, err := meta.Accessor(o)
+	if err != nil {
+		return true, nil, err
+	}
+
+	m.SetCreationTimestamp(mv1.NewTime(c.nextCreationTimestamp()))
+	if m.GetUID() == "" {
+		u, err := newUID()
+		if err != nil {
+			return true, nil, err
+		}
+		m.SetUID(u)
+	}
+
+	if s, ok := o.(*corev1.Secret); ok && s.Type == corev1.SecretTypeServiceAccountToken {
+		if err := c.populateTokenSecret(s); err != nil {
+			return true, nil, err
+		}
+	}
+
+	if err := c.Tracker().Create(a.GetResource(), o, a.GetNamespace()); err != nil {
+		return true, nil, err
+	}
+	return true, o.DeepCopyObject(), nil
+}
+
+// populateTokenSecret fills the secret with the data set by the token
+// controller. Secrets of missing service accounts are not populated.
+func (c *Cluster) populateTokenSecret(s *corev1.Secret) error {
+	// reactors run with the clientset locked, so the tracker is used directly
+	n := s.Annotations[corev1.ServiceAccountNameKey]
+	o, err := c.Tracker().Get(corev1.SchemeGroupVersion.WithResource("serviceaccounts"), s.Namespace, n)
+	if err != nil {
+		return nil
+	}
+	sa := o.(*corev1.ServiceAccount)
+
+	t, err := signToken(c.key, newTokenClaims(sa, s.Name))
+	if err != nil {
+		return err
+	}
+
+	s.Annotations[corev1.ServiceAccountUIDKey] = string(sa.UID)
+	if s.Data == nil {
+		s.Data = map[string][]byte{}
+	}
+	s.Data[corev1.ServiceAccountTokenKey] = []byte(t)
+	s.Data[corev1.ServiceAccountRootCAKey] = c.caCrt
+	s.Data[corev1.ServiceAccountNamespaceKey] = []byte(s.Namespace)
+	return nil
+}
+
+// listSecrets applies the type field selector, which is ignored by the fake clientset
+func (c *Cluster) listSecrets(action ktesting.Action) (bool, runtime.Object, error) {
+	a := action.(ktesting.ListAction)
+	fs := a.GetListRestrictions().Fields
+	if fs == nil || fs.Empty() {
+		return false, nil, nil
+	}
+
+	t, _ := fs.RequiresExactMatch("type")
+	o, err := c.Tracker().List(a.GetResource(), corev1.SchemeGroupVersion.WithKind("Secret"), a.GetNamespace())
+	if err != nil {
+		return true, nil, err
+	}
+
+	l := o.(*corev1.SecretList)
+	ss := l.Items[:0]
+	for _, s := range l.Items {
+		if t == "" || string(s.Type) == t {
+			ss = append(ss, s)
+		}
+	}
+	l.Items = ss
+	return true, l, nil
+}
+
+// reviewSelfSubject answers the SelfSubjectReview API with the simulated user,
+// in any of the versions it is served in
+func reviewSelfSubject(action ktesting.Action) (bool, runtime.Object, error) {
+	ui := authnv1.UserInfo{Username: Username, Groups: []string{"system:masters", "system:authenticated"}}
+	switch r := action.(ktesting.CreateAction).GetObject().DeepCopyObject().(type) {
+	case *authnv1.SelfSubjectReview:
+		r.Status.UserInfo = ui
+		return true, r, nil
+	case *authnv1beta1.SelfSubjectReview:
+		r.Status.UserInfo = ui
+		return true, r, nil
+	case *authnv1alpha1.SelfSubjectReview:
+		r.Status.UserInfo = ui
+		return true, r, nil
+	default:
+		return true, nil, fmt.Errorf("unexpected self subject review %T", r)
+	}
+}
+
+// reviewSelfSubjectAccess allows every request of the simulated user, who is
+// a member of system:masters
+func reviewSelfSubjectAccess(action ktesting.Action) (bool, runtime.Object, error) {
+	r := action.(ktesting.CreateAction).GetObject().DeepCopyObject().(*authzv1.SelfSubjectAccessReview)
+	r.Status = authzv1.SubjectAccessReviewStatus{Allowed: true}
+	return true, r, nil
+}
+
+// nextCreationTimestamp returns the clock's time, moved forward if needed
+// so that each object is created at least one second after the previous one
+func (c *Cluster) nextCreationTimestamp() time.Time {
+	t := c.clock().Truncate(time.Second)
+	if !t.After(c.last) {
+		t = c.last.Add(time.Second)
+	}
+	c.last = t
+	return t
+}
+
+func newUID() (types.UID, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return types.UID(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])), nil
+}
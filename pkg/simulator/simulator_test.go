@@ -0,0 +1,212 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testNamespace = "test-ns"
+
+func newTestCluster(t *testing.T) *Cluster {
+	t.Helper()
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, err := NewCluster(Options{Clock: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("unexpected error creating cluster: %v", err)
+	}
+	return c
+}
+
+func createTokenSecret(t *testing.T, c *Cluster, name string, sa string) *corev1.Secret {
+	t.Helper()
+
+	s := &corev1.Secret{
+		ObjectMeta: mv1.ObjectMeta{
+			Name:        name,
+			Namespace:   testNamespace,
+			Annotations: map[string]string{corev1.ServiceAccountNameKey: sa},
+		},
+		Type: corev1.SecretTypeServiceAccountToken,
+	}
+	s, err := c.CoreV1().Secrets(testNamespace).Create(context.TODO(), s, mv1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error creating secret: %v", err)
+	}
+	return s
+}
+
+func TestTokenController(t *testing.T) {
+	c := newTestCluster(t)
+	sa := &corev1.ServiceAccount{ObjectMeta: mv1.ObjectMeta{Name: "app", Namespace: testNamespace}}
+	sa, err := c.CoreV1().ServiceAccounts(testNamespace).Create(context.TODO(), sa, mv1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error creating service account: %v", err)
+	}
+	if sa.UID == "" {
+		t.Error("expected service account's UID to be set")
+	}
+
+	s := createTokenSecret(t, c, "app-key-0", "app")
+	if string(s.Data[corev1.ServiceAccountNamespaceKey]) != testNamespace {
+		t.Errorf("expected namespace '%s', found '%s'", testNamespace, s.Data[corev1.ServiceAccountNamespaceKey])
+	}
+	if string(s.Data[corev1.ServiceAccountRootCAKey]) != string(c.CACrt()) {
+		t.Error("expected the cluster's CA certificate in the secret")
+	}
+	if s.Annotations[corev1.ServiceAccountUIDKey] != string(sa.UID) {
+		t.Errorf("expected service account's UID annotation '%s', found '%s'", sa.UID, s.Annotations[corev1.ServiceAccountUIDKey])
+	}
+
+	tc, err := VerifyToken(&c.SigningKey().PublicKey, string(s.Data[corev1.ServiceAccountTokenKey]))
+	if err != nil {
+		t.Fatalf("unexpected error verifying token: %v", err)
+	}
+	if tc.Subject != "system:serviceaccount:test-ns:app" || tc.SecretName != "app-key-0" || tc.ServiceAccountUID != string(sa.UID) {
+		t.Errorf("unexpected token claims: %+v", tc)
+	}
+
+	p, _ := pem.Decode(c.CACrt())
+	if p == nil {
+		t.Fatal("expected a PEM encoded CA certificate")
+	}
+	if _, err := x509.ParseCertificate(p.Bytes); err != nil {
+		t.Errorf("unexpected error parsing CA certificate: %v", err)
+	}
+}
+
+func TestTokenControllerWithoutServiceAccount(t *testing.T) {
+	c := newTestCluster(t)
+
+	s := createTokenSecret(t, c, "app-key-0", "app")
+	if len(s.Data) != 0 {
+		t.Errorf("expected secret not to be populated, found %v", s.Data)
+	}
+}
+
+func TestCreationTimestamps(t *testing.T) {
+	c := newTestCluster(t)
+
+	s0 := createTokenSecret(t, c, "app-key-0", "app")
+	s1 := createTokenSecret(t, c, "app-key-1", "app")
+	if !s1.CreationTimestamp.After(s0.CreationTimestamp.Time) {
+		t.Errorf("expected secrets to be created in order, found %v and %v", s0.CreationTimestamp, s1.CreationTimestamp)
+	}
+}
+
+func TestListSecretsByType(t *testing.T) {
+	c := newTestCluster(t)
+	createTokenSecret(t, c, "app-key-0", "app")
+	o := &corev1.Secret{ObjectMeta: mv1.ObjectMeta{Name: "opaque", Namespace: testNamespace}}
+	if _, err := c.CoreV1().Secrets(testNamespace).Create(context.TODO(), o, mv1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error creating secret: %v", err)
+	}
+
+	ss, err := c.CoreV1().Secrets(testNamespace).List(context.TODO(), mv1.ListOptions{FieldSelector: "type=" + string(corev1.SecretTypeServiceAccountToken)})
+	if err != nil {
+		t.Fatalf("unexpected error listing secrets: %v", err)
+	}
+	if len(ss.Items) != 1 || ss.Items[0].Name != "app-key-0" {
+		t.Errorf("expected only the token secret, found %v", ss.Items)
+	}
+}
+
+func TestSaveAndLoadCluster(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "cluster.yaml")
+
+	c, err := LoadCluster(p, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error loading new cluster: %v", err)
+	}
+	if _, err := c.CoreV1().Namespaces().Get(context.TODO(), "default", mv1.GetOptions{}); err != nil {
+		t.Errorf("expected the default namespace in a new cluster: %v", err)
+	}
+	sa := &corev1.ServiceAccount{ObjectMeta: mv1.ObjectMeta{Name: "app", Namespace: testNamespace}}
+	if _, err := c.CoreV1().ServiceAccounts(testNamespace).Create(context.TODO(), sa, mv1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error creating service account: %v", err)
+	}
+	s0 := createTokenSecret(t, c, "app-key-0", "app")
+	if err := c.Save(p); err != nil {
+		t.Fatalf("unexpected error saving cluster: %v", err)
+	}
+
+	l, err := LoadCluster(p, Options{Clock: func() time.Time { return s0.CreationTimestamp.Time }})
+	if err != nil {
+		t.Fatalf("unexpected error loading cluster: %v", err)
+	}
+	if string(l.CACrt()) != string(c.CACrt()) {
+		t.Error("expected the CA certificate not to change")
+	}
+	ls, err := l.CoreV1().Secrets(testNamespace).Get(context.TODO(), "app-key-0", mv1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected secret to be loaded: %v", err)
+	}
+	if string(ls.Data[corev1.ServiceAccountTokenKey]) != string(s0.Data[corev1.ServiceAccountTokenKey]) {
+		t.Error("expected the token not to change")
+	}
+
+	s1 := createTokenSecret(t, l, "app-key-1", "app")
+	if !s1.CreationTimestamp.After(ls.CreationTimestamp.Time) {
+		t.Errorf("expected new secrets to be created after the loaded ones, found %v and %v", ls.CreationTimestamp, s1.CreationTimestamp)
+	}
+	if _, err := VerifyToken(&c.SigningKey().PublicKey, string(s1.Data[corev1.ServiceAccountTokenKey])); err != nil {
+		t.Errorf("expected new tokens to be signed by the same key: %v", err)
+	}
+}
+
+func TestSelfSubjectReview(t *testing.T) {
+	c := newTestCluster(t)
+
+	r, err := c.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(), &authnv1.SelfSubjectReview{}, mv1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u := r.Status.UserInfo.Username; u != Username {
+		t.Errorf("expected username '%s', found '%s'", Username, u)
+	}
+}
+
+func TestSelfSubjectAccessReview(t *testing.T) {
+	c := newTestCluster(t)
+
+	for i := 0; i < 2; i++ {
+		r, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), &authzv1.SelfSubjectAccessReview{}, mv1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !r.Status.Allowed {
+			t.Errorf("expected the request to be allowed, found %+v", r.Status)
+		}
+	}
+}
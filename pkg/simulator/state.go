@@ -0,0 +1,123 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	mv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// state is the content of the file storing a simulated cluster
+type state struct {
+	SigningKey      []byte                  `json:"signingKey"`
+	Namespaces      []corev1.Namespace      `json:"namespaces,omitempty"`
+	ServiceAccounts []corev1.ServiceAccount `json:"serviceAccounts,omitempty"`
+	Secrets         []corev1.Secret         `json:"secrets,omitempty"`
+}
+
+// LoadCluster returns the simulated cluster stored in the file at path or,
+// if the file does not exist, a new cluster with the 'default' namespace
+func LoadCluster(path string, opts Options) (*Cluster, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewCluster(opts, &corev1.Namespace{ObjectMeta: mv1.ObjectMeta{Name: "default"}})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := state{}
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("can not parse simulated cluster '%s': %w", path, err)
+	}
+
+	p, _ := pem.Decode(s.SigningKey)
+	if p == nil {
+		return nil, fmt.Errorf("can not parse simulated cluster '%s': invalid signing key", path)
+	}
+	k, err := x509.ParsePKCS1PrivateKey(p.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse simulated cluster '%s': invalid signing key: %w", path, err)
+	}
+	opts.SigningKey = k
+
+	oo := make([]runtime.Object, 0, len(s.Namespaces)+len(s.ServiceAccounts)+len(s.Secrets))
+	for i := range s.Namespaces {
+		oo = append(oo, &s.Namespaces[i])
+	}
+	for i := range s.ServiceAccounts {
+		oo = append(oo, &s.ServiceAccounts[i])
+	}
+	for i := range s.Secrets {
+		oo = append(oo, &s.Secrets[i])
+	}
+	return NewCluster(opts, oo...)
+}
+
+// Save stores the cluster's namespaces, service accounts and secrets in the
+// file at path, so that it can be loaded again with LoadCluster
+func (c *Cluster) Save(path string) error {
+	ctx := context.TODO()
+	nn, err := c.CoreV1().Namespaces().List(ctx, mv1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	sas, err := c.CoreV1().ServiceAccounts("").List(ctx, mv1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	ss, err := c.CoreV1().Secrets("").List(ctx, mv1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	// objects are sorted to keep the file stable between saves
+	sort.Slice(nn.Items, func(i, j int) bool { return nn.Items[i].Name < nn.Items[j].Name })
+	sort.Slice(sas.Items, func(i, j int) bool { return objectKey(&sas.Items[i]) < objectKey(&sas.Items[j]) })
+	sort.Slice(ss.Items, func(i, j int) bool { return objectKey(&ss.Items[i]) < objectKey(&ss.Items[j]) })
+
+	b, err := yaml.Marshal(state{
+		SigningKey:      pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(c.key)}),
+		Namespaces:      nn.Items,
+		ServiceAccounts: sas.Items,
+		Secrets:         ss.Items,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+func objectKey(o mv1.Object) string {
+	return o.GetNamespace() + "/" + o.GetName()
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Issuer is the issuer of the tokens, the same as the one of the token controller
+const Issuer = "kubernetes/serviceaccount"
+
+// TokenClaims are the claims of the tokens issued by the token controller
+type TokenClaims struct {
+	Issuer             string `json:"iss"`
+	Subject            string `json:"sub"`
+	Namespace          string `json:"kubernetes.io/serviceaccount/namespace"`
+	SecretName         string `json:"kubernetes.io/serviceaccount/secret.name"`
+	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name"`
+	ServiceAccountUID  string `json:"kubernetes.io/serviceaccount/service-account.uid"`
+}
+
+func newTokenClaims(sa *corev1.ServiceAccount, secretName string) TokenClaims {
+	return TokenClaims{
+		Issuer:             Issuer,
+		Subject:            fmt.Sprintf("system:serviceaccount:%s:%s", sa.Namespace, sa.Name),
+		Namespace:          sa.Namespace,
+		SecretName:         secretName,
+		ServiceAccountName: sa.Name,
+		ServiceAccountUID:  string(sa.UID),
+	}
+}
+
+// signToken returns the RS256 signed JWT with the given claims
+func signToken(key *rsa.PrivateKey, claims TokenClaims) (string, error) {
+	h, err := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID(&key.PublicKey)})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	u := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	d := sha256.Sum256([]byte(u))
+	s, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, d[:])
+	if err != nil {
+		return "", err
+	}
+	return u + "." + base64.RawURLEncoding.EncodeToString(s), nil
+}
+
+// VerifyToken checks the token's signature and returns its claims
+func VerifyToken(key *rsa.PublicKey, token string) (*TokenClaims, error) {
+	pp := strings.Split(token, ".")
+	if len(pp) != 3 {
+		return nil, fmt.Errorf("token is not a JWT")
+	}
+
+	s, err := base64.RawURLEncoding.DecodeString(pp[2])
+	if err != nil {
+		return nil, fmt.Errorf("can not decode token signature: %w", err)
+	}
+	d := sha256.Sum256([]byte(pp[0] + "." + pp[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, d[:], s); err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+
+	p, err := base64.RawURLEncoding.DecodeString(pp[1])
+	if err != nil {
+		return nil, fmt.Errorf("can not decode token claims: %w", err)
+	}
+	c := &TokenClaims{}
+	if err := json.Unmarshal(p, c); err != nil {
+		return nil, fmt.Errorf("can not parse token claims: %w", err)
+	}
+	return c, nil
+}
+
+// keyID returns the id of the key as set in the tokens' header
+func keyID(key *rsa.PublicKey) string {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	d := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(d[:])
+}
+
+// newCACertificate returns a PEM encoded self-signed CA certificate for the
+// simulated API server. The certificate depends only on the key, so that it
+// does not change when a simulated cluster is loaded again.
+func newCACertificate(key *rsa.PrivateKey) ([]byte, error) {
+	t := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kid-simulator-ca"},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	d, err := x509.CreateCertificate(rand.Reader, t, t, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: d}), nil
+}
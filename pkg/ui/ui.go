@@ -0,0 +1,542 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package ui
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/filariow/kid/pkg/audit"
+	"github.com/filariow/kid/pkg/identity"
+	"github.com/filariow/kid/pkg/kube"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	pageMain       = "main"
+	pageConfirm    = "confirm"
+	pageKubeconfig = "kubeconfig"
+
+	// requestTimeout bounds each request made to the cluster
+	requestTimeout = 10 * time.Second
+
+	helpText = "[yellow]tab[-] switch pane  [yellow]b[-] begin rotation  [yellow]c[-] complete rotation  " +
+		"[yellow]r[-] revoke  [yellow]o[-] roll back  [yellow]k[-] kubeconfig  [yellow]y[-] copy kubeconfig  " +
+		"[yellow]ctrl-r[-] refresh  [yellow]q[-] quit"
+)
+
+// Options configures the terminal UI
+type Options struct {
+	// Namespace is the namespace selected at start
+	Namespace string
+	// Kubeconfig are the options for the kubeconfigs viewed and copied
+	Kubeconfig identity.GetKubeconfigOptions
+	// ManagerOptions configure the Manager operating on the identities
+	ManagerOptions []identity.ManagerOption
+	// Audit records the operations in the audit log, nil if it is not enabled
+	Audit *audit.Recorder
+}
+
+// version is a token version of the selected identity
+type version struct {
+	Version uint64
+	Revoked bool
+	Current bool
+}
+
+// UI is a full-screen terminal UI to browse namespaces, identities and their
+// token versions, and to operate on them with the same functions the CLI uses
+type UI struct {
+	ctx  context.Context
+	cli  kubernetes.Interface
+	m    *identity.Manager
+	opts Options
+
+	app        *tview.Application
+	pages      *tview.Pages
+	namespaces *tview.List
+	identities *tview.List
+	versions   *tview.List
+	status     *tview.TextView
+
+	namespace string
+	identity  string
+	vv        []version
+}
+
+// Run shows the terminal UI until the user quits or the context is done
+func Run(ctx context.Context, cli kubernetes.Interface, opts Options) error {
+	u := newUI(ctx, cli, opts)
+
+	go func() {
+		<-ctx.Done()
+		u.app.Stop()
+	}()
+
+	u.loadNamespaces()
+	return u.app.Run()
+}
+
+func newUI(ctx context.Context, cli kubernetes.Interface, opts Options) *UI {
+	u := &UI{
+		ctx:        ctx,
+		cli:        cli,
+		m:          newManager(cli, opts),
+		opts:       opts,
+		app:        tview.NewApplication(),
+		pages:      tview.NewPages(),
+		namespaces: newList("Namespaces"),
+		identities: newList("Identities"),
+		versions:   newList("Token versions"),
+		status:     tview.NewTextView().SetDynamicColors(true),
+	}
+
+	u.namespaces.SetChangedFunc(func(_ int, ns string, _ string, _ rune) {
+		u.namespace = ns
+		u.loadIdentities()
+	})
+	u.identities.SetChangedFunc(func(_ int, name string, _ string, _ rune) {
+		u.identity = name
+		u.loadVersions()
+	})
+
+	panes := tview.NewFlex().
+		AddItem(u.namespaces, 0, 1, true).
+		AddItem(u.identities, 0, 1, false).
+		AddItem(u.versions, 0, 1, false)
+	help := tview.NewTextView().SetDynamicColors(true).SetText(helpText)
+	main := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, true).
+		AddItem(u.status, 1, 0, false).
+		AddItem(help, 1, 0, false)
+
+	u.pages.AddPage(pageMain, main, true, true)
+	u.app.SetRoot(u.pages, true).SetInputCapture(u.handleKey)
+	return u
+}
+
+func newList(title string) *tview.List {
+	l := tview.NewList().ShowSecondaryText(false).SetHighlightFullLine(true)
+	l.SetBorder(true).SetTitle(" " + title + " ")
+	return l
+}
+
+// handleKey handles the key bindings of the main page
+func (u *UI) handleKey(e *tcell.EventKey) *tcell.EventKey {
+	if f, _ := u.pages.GetFrontPage(); f != pageMain {
+		return e
+	}
+
+	switch e.Key() {
+	case tcell.KeyTab:
+		u.switchPane()
+		return nil
+	case tcell.KeyCtrlR:
+		u.loadNamespaces()
+		return nil
+	case tcell.KeyRune:
+	default:
+		return e
+	}
+
+	switch e.Rune() {
+	case 'q':
+		u.app.Stop()
+	case 'b':
+		u.beginRotation()
+	case 'c':
+		u.completeRotation()
+	case 'r':
+		u.revoke()
+	case 'o':
+		u.rollback()
+	case 'k':
+		u.viewKubeconfig()
+	case 'y':
+		u.copyKubeconfig()
+	default:
+		return e
+	}
+	return nil
+}
+
+func (u *UI) switchPane() {
+	switch u.app.GetFocus() {
+	case u.namespaces:
+		u.app.SetFocus(u.identities)
+	case u.identities:
+		u.app.SetFocus(u.versions)
+	default:
+		u.app.SetFocus(u.namespaces)
+	}
+}
+
+// loadNamespaces lists the namespaces, falling back to the initial one
+// if namespaces can not be listed
+func (u *UI) loadNamespaces() {
+	u.load("namespaces", func(ctx context.Context) (func(), error) {
+		nn, err := kube.ListNamespaces(ctx, u.cli)
+		if err != nil {
+			nn = []string{u.opts.Namespace}
+		}
+
+		return func() {
+			sel := u.namespace
+			if sel == "" {
+				sel = u.opts.Namespace
+			}
+			setItems(u.namespaces, nn, sel)
+		}, nil
+	})
+}
+
+func (u *UI) loadIdentities() {
+	ns := u.namespace
+	u.load("identities", func(ctx context.Context) (func(), error) {
+		ii, err := u.m.ListIdentities(ctx, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		nn := make([]string, 0, len(ii))
+		for _, i := range ii {
+			nn = append(nn, i.Name)
+		}
+
+		return func() {
+			if ns != u.namespace {
+				return
+			}
+			setItems(u.identities, nn, u.identity)
+			if len(nn) == 0 {
+				u.identity = ""
+				u.vv = nil
+				u.versions.Clear()
+			}
+		}, nil
+	})
+}
+
+func (u *UI) loadVersions() {
+	ns, name := u.namespace, u.identity
+	u.load("token versions", func(ctx context.Context) (func(), error) {
+		vv, err := listVersions(ctx, u.m, name, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() {
+			if ns != u.namespace || name != u.identity {
+				return
+			}
+
+			u.vv = vv
+			cur := u.versions.GetCurrentItem()
+			u.versions.Clear()
+			for _, v := range vv {
+				t := strconv.FormatUint(v.Version, 10)
+				switch {
+				case v.Current:
+					t += " [green](current)"
+				case v.Revoked:
+					t += " [red](revoked)"
+				}
+				u.versions.AddItem(t, "", 0, nil)
+			}
+			if cur < len(vv) {
+				u.versions.SetCurrentItem(cur)
+			}
+		}, nil
+	})
+}
+
+// newManager returns the Manager configured with the options, recording the
+// operations in the audit log if it is enabled, as the CLI does
+func newManager(cli kubernetes.Interface, opts Options) *identity.Manager {
+	oo := append([]identity.ManagerOption{}, opts.ManagerOptions...)
+	if opts.Audit != nil {
+		oo = append(oo, identity.WithAuditor(opts.Audit))
+	}
+	return identity.NewManager(cli, oo...)
+}
+
+// listVersions returns the existing and the revoked token versions
+// of the identity, the last one first
+func listVersions(ctx context.Context, m *identity.Manager, name string, namespace string) ([]version, error) {
+	tvs, err := m.ListTokenVersions(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ee := make([]uint64, 0, len(tvs))
+	for _, tv := range tvs {
+		ee = append(ee, tv.Version)
+	}
+
+	rr, err := m.ListRevokedTokenVersions(ctx, name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur uint64
+	if s, err := m.GetLastTokenSecret(ctx, name, namespace); err == nil {
+		cur, _ = m.GetTokenVersion(s)
+	}
+
+	vv := make([]version, 0, len(ee)+len(rr))
+	for i, j := len(ee)-1, len(rr)-1; i >= 0 || j >= 0; {
+		if j < 0 || (i >= 0 && ee[i] > rr[j]) {
+			vv = append(vv, version{Version: ee[i], Current: ee[i] == cur})
+			i--
+			continue
+		}
+		vv = append(vv, version{Version: rr[j], Revoked: true})
+		j--
+	}
+	return vv, nil
+}
+
+func setItems(l *tview.List, ii []string, selected string) {
+	l.Clear()
+	for _, i := range ii {
+		l.AddItem(i, "", 0, nil)
+	}
+
+	for n, i := range ii {
+		if i == selected {
+			l.SetCurrentItem(n)
+		}
+	}
+}
+
+// selectedVersion returns the token version selected in the versions pane
+func (u *UI) selectedVersion() (*version, bool) {
+	if u.app.GetFocus() != u.versions {
+		return nil, false
+	}
+
+	i := u.versions.GetCurrentItem()
+	if i < 0 || i >= len(u.vv) {
+		return nil, false
+	}
+	return &u.vv[i], true
+}
+
+func (u *UI) beginRotation() {
+	if u.identity == "" {
+		return
+	}
+
+	ns, name := u.namespace, u.identity
+	u.confirm(fmt.Sprintf("Begin the rotation of identity '%s/%s'?\nA new token version will be created.", ns, name), func(ctx context.Context) (string, error) {
+		rs, err := u.m.BeginRotation(ctx, name, ns)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("created secret '%s/%s'", rs.Current.Namespace, rs.Current.Secret), nil
+	})
+}
+
+func (u *UI) completeRotation() {
+	if u.identity == "" {
+		return
+	}
+
+	ns, name := u.namespace, u.identity
+	u.confirm(fmt.Sprintf("Complete the rotation of identity '%s/%s'?\nThe previous token version will be deleted and the token revoked.", ns, name), func(ctx context.Context) (string, error) {
+		tv, err := u.m.CompleteRotation(ctx, name, ns)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("deleted secret '%s/%s'", tv.Namespace, tv.Secret), nil
+	})
+}
+
+func (u *UI) revoke() {
+	v, ok := u.selectedVersion()
+	if !ok || v.Revoked {
+		u.setError("select an existing token version to revoke")
+		return
+	}
+
+	ns, name, ver := u.namespace, u.identity, v.Version
+	u.confirm(fmt.Sprintf("Revoke token version %d of identity '%s/%s'?\nThe secret will be deleted and the token revoked.", ver, ns, name), func(ctx context.Context) (string, error) {
+		tv, err := u.m.RevokeTokenVersion(ctx, name, ns, ver)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("deleted secret '%s/%s'", tv.Namespace, tv.Secret), nil
+	})
+}
+
+func (u *UI) rollback() {
+	v, ok := u.selectedVersion()
+	if !ok || !v.Revoked {
+		u.setError("select a revoked token version to roll back")
+		return
+	}
+
+	ns, name, ver := u.namespace, u.identity, v.Version
+	u.confirm(fmt.Sprintf("Roll back token version %d of identity '%s/%s'?\nA new token will be issued for the version.", ver, ns, name), func(ctx context.Context) (string, error) {
+		tv, err := u.m.RollbackTokenVersion(ctx, name, ns, ver)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rolled back secret '%s/%s'", tv.Namespace, tv.Secret), nil
+	})
+}
+
+func (u *UI) viewKubeconfig() {
+	u.withKubeconfig(func(kfg []byte) {
+		tv := tview.NewTextView().SetText(string(kfg)).SetScrollable(true)
+		tv.SetBorder(true).SetTitle(fmt.Sprintf(" Kubeconfig of '%s/%s' - y: copy, esc: back ", u.namespace, u.identity))
+		tv.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+			switch {
+			case e.Key() == tcell.KeyEsc, e.Rune() == 'q':
+				u.pages.RemovePage(pageKubeconfig)
+				return nil
+			case e.Rune() == 'y':
+				u.copy(kfg)
+				return nil
+			}
+			return e
+		})
+		u.pages.AddPage(pageKubeconfig, tv, true, true)
+	})
+}
+
+func (u *UI) copyKubeconfig() {
+	u.withKubeconfig(u.copy)
+}
+
+// withKubeconfig builds the kubeconfig of the selected identity and
+// passes it to f, on the UI goroutine
+func (u *UI) withKubeconfig(f func([]byte)) {
+	if u.identity == "" {
+		return
+	}
+
+	ns, name := u.namespace, u.identity
+	u.load("kubeconfig", func(ctx context.Context) (func(), error) {
+		s, err := u.m.GetLastTokenSecret(ctx, name, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		tkn, err := identity.GetToken(s)
+		if err != nil {
+			return nil, err
+		}
+
+		kfg, err := identity.GetKubeconfig(u.cli, tkn, u.opts.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return func() { f(kfg) }, nil
+	})
+}
+
+// copy copies data to the clipboard with the OSC 52 terminal escape sequence,
+// which works over SSH too
+func (u *UI) copy(data []byte) {
+	w, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		u.setError(fmt.Sprintf("can not copy to the clipboard: %v", err))
+		return
+	}
+	defer w.Close()
+
+	if _, err := fmt.Fprintf(w, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString(data)); err != nil {
+		u.setError(fmt.Sprintf("can not copy to the clipboard: %v", err))
+		return
+	}
+	u.setStatus("kubeconfig copied to the clipboard")
+}
+
+// confirm asks the user to confirm the action and, if confirmed, runs it
+// and reloads the token versions
+func (u *UI) confirm(text string, action func(ctx context.Context) (string, error)) {
+	focus := u.app.GetFocus()
+	m := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Cancel", "Confirm"}).
+		SetDoneFunc(func(_ int, label string) {
+			u.pages.RemovePage(pageConfirm)
+			u.app.SetFocus(focus)
+			if label != "Confirm" {
+				return
+			}
+
+			u.load("", func(ctx context.Context) (func(), error) {
+				msg, err := action(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return func() {
+					u.setStatus(msg)
+					u.loadVersions()
+				}, nil
+			})
+		})
+	u.pages.AddPage(pageConfirm, m, true, true)
+}
+
+// load runs f in the background, with a timeout, and applies its result on the
+// UI goroutine. Errors are shown in the status bar.
+func (u *UI) load(what string, f func(ctx context.Context) (func(), error)) {
+	msg := fmt.Sprintf("loading %s...", what)
+	if what != "" {
+		u.setStatus(msg)
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(u.ctx, requestTimeout)
+		defer cancel()
+
+		apply, err := f(ctx)
+		u.app.QueueUpdateDraw(func() {
+			if err != nil {
+				u.setError(err.Error())
+				return
+			}
+			if what != "" && u.status.GetText(true) == msg {
+				u.setStatus("")
+			}
+			apply()
+		})
+	}()
+}
+
+func (u *UI) setStatus(s string) {
+	u.status.SetText(tview.Escape(s))
+}
+
+func (u *UI) setError(s string) {
+	u.status.SetText("[red]" + tview.Escape(s))
+}
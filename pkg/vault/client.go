@@ -0,0 +1,280 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	EnvAddress       = "VAULT_ADDR"
+	EnvToken         = "VAULT_TOKEN"
+	EnvNamespace     = "VAULT_NAMESPACE"
+	EnvCACert        = "VAULT_CACERT"
+	EnvRoleID        = "VAULT_ROLE_ID"
+	EnvSecretID      = "VAULT_SECRET_ID"
+	EnvAppRoleMount  = "VAULT_APPROLE_MOUNT"
+	defaultAddress   = "https://127.0.0.1:8200"
+	defaultAppRole   = "approle"
+	headerToken      = "X-Vault-Token"
+	headerNamespace  = "X-Vault-Namespace"
+	headerRequest    = "X-Vault-Request"
+	maxErrorBodySize = 4096
+)
+
+var ErrMissingCredentials = fmt.Errorf("no Vault credentials found: set %s or %s and %s", EnvToken, EnvRoleID, EnvSecretID)
+
+// Client is a minimal client for Vault's HTTP API
+type Client struct {
+	Address    string
+	Token      string
+	Namespace  string
+	HTTPClient *http.Client
+
+	// appRole holds the credentials the client logged in with, if any,
+	// to log in again when the token expires
+	appRole *appRoleCredentials
+}
+
+type appRoleCredentials struct {
+	mount    string
+	roleID   string
+	secretID string
+}
+
+// NewClientFromEnv builds a client configured from the standard Vault
+// environment variables.
+// If VAULT_TOKEN is not set, it logs in with AppRole using VAULT_ROLE_ID and VAULT_SECRET_ID.
+func NewClientFromEnv(ctx context.Context) (*Client, error) {
+	c := &Client{
+		Address:    defaultAddress,
+		Token:      os.Getenv(EnvToken),
+		Namespace:  os.Getenv(EnvNamespace),
+		HTTPClient: http.DefaultClient,
+	}
+	if a := os.Getenv(EnvAddress); a != "" {
+		c.Address = a
+	}
+
+	if ca := os.Getenv(EnvCACert); ca != "" {
+		hc, err := newHTTPClientWithCA(ca)
+		if err != nil {
+			return nil, err
+		}
+		c.HTTPClient = hc
+	}
+
+	if c.Token != "" {
+		return c, nil
+	}
+
+	rid, sid := os.Getenv(EnvRoleID), os.Getenv(EnvSecretID)
+	if rid == "" || sid == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	m := os.Getenv(EnvAppRoleMount)
+	if m == "" {
+		m = defaultAppRole
+	}
+	if err := c.LoginAppRole(ctx, m, rid, sid); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoginAppRole logs in with AppRole and sets the client token.
+// The client logs in again when Vault rejects the token, e.g. once its TTL expired.
+func (c *Client) LoginAppRole(ctx context.Context, mount string, roleID string, secretID string) error {
+	c.appRole = &appRoleCredentials{mount: mount, roleID: roleID, secretID: secretID}
+	return c.loginAppRole(ctx)
+}
+
+func (c *Client) loginAppRole(ctx context.Context) error {
+	b := map[string]string{"role_id": c.appRole.roleID, "secret_id": c.appRole.secretID}
+	r := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+
+	if err := c.send(ctx, http.MethodPost, fmt.Sprintf("auth/%s/login", c.appRole.mount), b, &r); err != nil {
+		return fmt.Errorf("error logging in to Vault with AppRole: %w", err)
+	}
+	if r.Auth.ClientToken == "" {
+		return fmt.Errorf("error logging in to Vault with AppRole: no token returned")
+	}
+
+	c.Token = r.Auth.ClientToken
+	return nil
+}
+
+// WriteKV writes a new version of the secret at the given path of a KV v2
+// engine, and returns the version created.
+func (c *Client) WriteKV(ctx context.Context, mount string, path string, data map[string]string) (int, error) {
+	b := map[string]interface{}{"data": data}
+	r := struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}{}
+
+	if err := c.do(ctx, http.MethodPost, kvPath(mount, "data", path), b, &r); err != nil {
+		return 0, fmt.Errorf("error writing secret '%s/%s' to Vault: %w", mount, path, err)
+	}
+	return r.Data.Version, nil
+}
+
+// WriteKVMetadata sets the custom metadata of the secret at the given path
+// of a KV v2 engine.
+func (c *Client) WriteKVMetadata(ctx context.Context, mount string, path string, metadata map[string]string) error {
+	b := map[string]interface{}{"custom_metadata": metadata}
+
+	if err := c.do(ctx, http.MethodPost, kvPath(mount, "metadata", path), b, nil); err != nil {
+		return fmt.Errorf("error writing metadata of secret '%s/%s' to Vault: %w", mount, path, err)
+	}
+	return nil
+}
+
+// do sends the request. If Vault rejects the token of a client logged in
+// with AppRole, it logs in again and retries once.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	err := c.send(ctx, method, path, body, out)
+	re := &ResponseError{}
+	if c.appRole == nil || !errors.As(err, &re) || re.StatusCode != http.StatusForbidden {
+		return err
+	}
+
+	if err := c.loginAppRole(ctx); err != nil {
+		return err
+	}
+	return c.send(ctx, method, path, body, out)
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	j, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	u := strings.TrimSuffix(c.Address, "/") + "/v1/" + path
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerRequest, "true")
+	if c.Token != "" {
+		req.Header.Set(headerToken, c.Token)
+	}
+	if c.Namespace != "" {
+		req.Header.Set(headerNamespace, c.Namespace)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newResponseError(res)
+	}
+
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+type ResponseError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *ResponseError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault responded with status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault responded with status code %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+func newResponseError(res *http.Response) error {
+	e := &ResponseError{StatusCode: res.StatusCode}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	if err != nil {
+		return e
+	}
+
+	r := struct {
+		Errors []string `json:"errors"`
+	}{}
+	if err := json.Unmarshal(b, &r); err == nil {
+		e.Errors = r.Errors
+	}
+	return e
+}
+
+func newHTTPClientWithCA(path string) (*http.Client, error) {
+	ca, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := x509.NewCertPool()
+	if !p.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in '%s'", path)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: p, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: t}, nil
+}
+
+func kvPath(mount string, kind string, path string) string {
+	return strings.Trim(mount, "/") + "/" + kind + "/" + escapePath(strings.Trim(path, "/"))
+}
+
+func escapePath(p string) string {
+	ss := strings.Split(p, "/")
+	for i, s := range ss {
+		ss[i] = url.PathEscape(s)
+	}
+	return strings.Join(ss, "/")
+}
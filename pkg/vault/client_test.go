@@ -0,0 +1,280 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testToken    = "s.test-token"
+	testRoleID   = "role"
+	testSecretID = "secret"
+)
+
+// fakeVault serves the subset of Vault's HTTP API used by the client:
+// AppRole login and KV v2 data and metadata writes
+type fakeVault struct {
+	*httptest.Server
+
+	// appRoleMount is the path where AppRole is mounted
+	appRoleMount string
+
+	mu sync.Mutex
+	// token is the only token accepted, the one returned on login
+	token      string
+	logins     int
+	data       map[string]map[string]string
+	metadata   map[string]map[string]string
+	versions   map[string]int
+	namespaces []string
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+
+	v := &fakeVault{
+		appRoleMount: defaultAppRole,
+		token:        testToken,
+		data:         map[string]map[string]string{},
+		metadata:     map[string]map[string]string{},
+		versions:     map[string]int{},
+	}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.namespaces = append(v.namespaces, r.Header.Get(headerNamespace))
+	if r.Method != http.MethodPost || r.Header.Get(headerRequest) != "true" {
+		writeErrors(w, http.StatusBadRequest, "unexpected request")
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.EscapedPath(), "/v1/")
+	if p == "auth/"+v.appRoleMount+"/login" {
+		b := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil || b["role_id"] != testRoleID || b["secret_id"] != testSecretID {
+			writeErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		v.logins++
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": v.token}})
+		return
+	}
+
+	if r.Header.Get(headerToken) != v.token {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(p, "secret/data/"):
+		b := struct {
+			Data map[string]string `json:"data"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		k := strings.TrimPrefix(p, "secret/data/")
+		v.data[k] = b.Data
+		v.versions[k]++
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": v.versions[k]}})
+	case strings.HasPrefix(p, "secret/metadata/"):
+		b := struct {
+			CustomMetadata map[string]string `json:"custom_metadata"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v.metadata[strings.TrimPrefix(p, "secret/metadata/")] = b.CustomMetadata
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func writeErrors(w http.ResponseWriter, code int, ee ...string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": ee})
+}
+
+// setVaultEnv clears the Vault environment variables and sets the given ones
+func setVaultEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for _, k := range []string{EnvAddress, EnvToken, EnvNamespace, EnvCACert, EnvRoleID, EnvSecretID, EnvAppRoleMount} {
+		t.Setenv(k, env[k])
+	}
+}
+
+func TestWriteKV(t *testing.T) {
+	v := newFakeVault(t)
+	c := &Client{Address: v.URL, Token: testToken, Namespace: "team"}
+
+	for i := 1; i <= 2; i++ {
+		n, err := c.WriteKV(context.TODO(), "secret", "kid/app", map[string]string{"token": "t"})
+		if err != nil {
+			t.Fatalf("unexpected error writing secret: %v", err)
+		}
+		if n != i {
+			t.Errorf("expected version %d, found %d", i, n)
+		}
+	}
+
+	if d := v.data["kid/app"]; d["token"] != "t" {
+		t.Errorf("expected the secret data to be written, found %v", d)
+	}
+	for _, ns := range v.namespaces {
+		if ns != "team" {
+			t.Errorf("expected requests in namespace 'team', found '%s'", ns)
+		}
+	}
+}
+
+func TestWriteKVEscapesPath(t *testing.T) {
+	v := newFakeVault(t)
+	c := &Client{Address: v.URL + "/", Token: testToken}
+
+	if _, err := c.WriteKV(context.TODO(), "/secret/", "/kid/my app/", map[string]string{"token": "t"}); err != nil {
+		t.Fatalf("unexpected error writing secret: %v", err)
+	}
+	if _, ok := v.data["kid/my%20app"]; !ok {
+		t.Errorf("expected the path segments to be escaped, found %v", v.data)
+	}
+}
+
+func TestWriteKVResponseError(t *testing.T) {
+	v := newFakeVault(t)
+	c := &Client{Address: v.URL, Token: "invalid"}
+
+	_, err := c.WriteKV(context.TODO(), "secret", "kid/app", map[string]string{"token": "t"})
+	re := &ResponseError{}
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a response error, found: %v", err)
+	}
+	if re.StatusCode != http.StatusForbidden || len(re.Errors) != 1 || re.Errors[0] != "permission denied" {
+		t.Errorf("expected status code 403 and error 'permission denied', found %d and %v", re.StatusCode, re.Errors)
+	}
+}
+
+func TestNewClientFromEnvToken(t *testing.T) {
+	v := newFakeVault(t)
+	setVaultEnv(t, map[string]string{EnvAddress: v.URL, EnvToken: testToken, EnvNamespace: "team"})
+
+	c, err := NewClientFromEnv(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Address != v.URL || c.Token != testToken || c.Namespace != "team" {
+		t.Errorf("expected the client to be configured from the environment, found %+v", c)
+	}
+	if len(v.namespaces) != 0 {
+		t.Errorf("expected no login with a token, found %d requests", len(v.namespaces))
+	}
+}
+
+func TestNewClientFromEnvAppRole(t *testing.T) {
+	v := newFakeVault(t)
+	setVaultEnv(t, map[string]string{EnvAddress: v.URL, EnvRoleID: testRoleID, EnvSecretID: testSecretID})
+
+	c, err := NewClientFromEnv(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error logging in with AppRole: %v", err)
+	}
+	if c.Token != testToken {
+		t.Errorf("expected the client token to be '%s', found '%s'", testToken, c.Token)
+	}
+	if _, err := c.WriteKV(context.TODO(), "secret", "kid/app", map[string]string{"token": "t"}); err != nil {
+		t.Errorf("unexpected error writing secret with the AppRole token: %v", err)
+	}
+}
+
+func TestAppRoleLoginOnExpiredToken(t *testing.T) {
+	v := newFakeVault(t)
+	setVaultEnv(t, map[string]string{EnvAddress: v.URL, EnvRoleID: testRoleID, EnvSecretID: testSecretID})
+
+	c, err := NewClientFromEnv(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error logging in with AppRole: %v", err)
+	}
+
+	// the token expires: only a new one is accepted
+	v.token = "s.renewed-token"
+	if _, err := c.WriteKV(context.TODO(), "secret", "kid/app", map[string]string{"token": "t"}); err != nil {
+		t.Fatalf("unexpected error writing secret after the token expired: %v", err)
+	}
+	if c.Token != v.token {
+		t.Errorf("expected the client token to be '%s', found '%s'", v.token, c.Token)
+	}
+	if v.logins != 2 {
+		t.Errorf("expected the client to log in again once, found %d logins", v.logins)
+	}
+	if d := v.data["kid/app"]; d["token"] != "t" {
+		t.Errorf("expected the secret data to be written, found %v", d)
+	}
+}
+
+func TestNewClientFromEnvAppRoleMount(t *testing.T) {
+	v := newFakeVault(t)
+	v.appRoleMount = "ci"
+	setVaultEnv(t, map[string]string{EnvAddress: v.URL, EnvRoleID: testRoleID, EnvSecretID: testSecretID, EnvAppRoleMount: "ci"})
+
+	c, err := NewClientFromEnv(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error logging in with AppRole mounted at 'ci': %v", err)
+	}
+	if c.Token != testToken {
+		t.Errorf("expected the client token to be '%s', found '%s'", testToken, c.Token)
+	}
+}
+
+func TestNewClientFromEnvAppRoleInvalid(t *testing.T) {
+	v := newFakeVault(t)
+	setVaultEnv(t, map[string]string{EnvAddress: v.URL, EnvRoleID: testRoleID, EnvSecretID: "wrong"})
+
+	if _, err := NewClientFromEnv(context.TODO()); err == nil || !strings.Contains(err.Error(), "invalid role or secret ID") {
+		t.Errorf("expected the login to fail, found: %v", err)
+	}
+}
+
+func TestNewClientFromEnvMissingCredentials(t *testing.T) {
+	setVaultEnv(t, map[string]string{EnvRoleID: testRoleID})
+
+	if _, err := NewClientFromEnv(context.TODO()); !errors.Is(err, ErrMissingCredentials) {
+		t.Errorf("expected error %v, found: %v", ErrMissingCredentials, err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/filariow/kid/pkg/identity"
+)
+
+const Scheme = "vault"
+
+const (
+	keyToken      = "token"
+	keyCACrt      = "ca.crt"
+	keyNamespace  = "namespace"
+	keyKubeconfig = "kubeconfig"
+
+	metadataIdentity     = "kid-identity"
+	metadataNamespace    = "kid-namespace"
+	metadataTokenVersion = "kid-token-version"
+)
+
+var ErrInvalidURL = fmt.Errorf("invalid Vault URL")
+
+func init() {
+	identity.RegisterExporter(Scheme, newExporter)
+}
+
+// Target is a secret path in a KV v2 engine
+type Target struct {
+	Mount string
+	Path  string
+}
+
+// ParseURL parses a target in the form 'vault://<mount>/<path>'
+func ParseURL(raw string) (*Target, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %v", ErrInvalidURL, raw, err)
+	}
+
+	p := strings.Trim(u.Path, "/")
+	if u.Scheme != Scheme || u.Host == "" || p == "" {
+		return nil, fmt.Errorf("%w '%s': expected format is '%s://<mount>/<path>'", ErrInvalidURL, raw, Scheme)
+	}
+
+	return &Target{Mount: u.Host, Path: p}, nil
+}
+
+// Push writes the token, the CA and the kubeconfig into the target secret
+// and records identity and token version in the secret's custom metadata
+func Push(ctx context.Context, cli *Client, t Target, c *identity.Credentials) error {
+	d := map[string]string{
+		keyToken:      string(c.Token.Token),
+		keyCACrt:      string(c.Token.CACrt),
+		keyNamespace:  string(c.Token.Namespace),
+		keyKubeconfig: string(c.Kubeconfig),
+	}
+	if _, err := cli.WriteKV(ctx, t.Mount, t.Path, d); err != nil {
+		return err
+	}
+
+	m := map[string]string{
+		metadataIdentity:     c.Identity,
+		metadataNamespace:    c.Namespace,
+		metadataTokenVersion: strconv.FormatUint(c.Version, 10),
+	}
+	return cli.WriteKVMetadata(ctx, t.Mount, t.Path, m)
+}
+
+type exporter struct {
+	cli    *Client
+	target Target
+}
+
+// newExporter builds an exporter for 'vault://<mount>/<path>' configured
+// from the environment
+func newExporter(ctx context.Context, target *url.URL, opts identity.ExporterOptions) (identity.Exporter, error) {
+	if opts.Encode != nil {
+		return nil, fmt.Errorf("encryption is not supported when exporting to Vault")
+	}
+	if opts.Format != "" {
+		return nil, fmt.Errorf("format '%s' is not supported when exporting to Vault: token, CA and kubeconfig are written as separate keys", opts.Format)
+	}
+
+	t, err := ParseURL(target.String())
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := NewClientFromEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &exporter{cli: cli, target: *t}, nil
+}
+
+func (e *exporter) Export(ctx context.Context, c *identity.Credentials) error {
+	return Push(ctx, e.cli, e.target, c)
+}
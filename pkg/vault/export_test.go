@@ -0,0 +1,124 @@
+/*
+Copyright © 2023 Francesco Ilario
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filariow/kid/pkg/identity"
+)
+
+func newTestCredentials() *identity.Credentials {
+	return &identity.Credentials{
+		Identity:  "app",
+		Namespace: "test-ns",
+		Version:   3,
+		Token: &identity.ServiceAccountToken{
+			Token:     []byte("token"),
+			CACrt:     []byte("ca"),
+			Namespace: []byte("test-ns"),
+		},
+		Kubeconfig: []byte("kubeconfig"),
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tt := []struct {
+		url      string
+		expected *Target
+	}{
+		{url: "vault://secret/kid/app", expected: &Target{Mount: "secret", Path: "kid/app"}},
+		{url: "vault://secret/kid/app/", expected: &Target{Mount: "secret", Path: "kid/app"}},
+		{url: "vault://secret"},
+		{url: "vault:///kid/app"},
+		{url: "file://secret/kid/app"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.url, func(t *testing.T) {
+			p, err := ParseURL(tc.url)
+			if tc.expected == nil {
+				if !errors.Is(err, ErrInvalidURL) {
+					t.Errorf("expected error %v, found: %v", ErrInvalidURL, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *p != *tc.expected {
+				t.Errorf("expected target %+v, found %+v", *tc.expected, *p)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	v := newFakeVault(t)
+	setVaultEnv(t, map[string]string{EnvAddress: v.URL, EnvToken: testToken})
+
+	e, err := identity.NewExporter(context.TODO(), "vault://secret/kid/app", identity.ExporterOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error building the exporter: %v", err)
+	}
+	if err := e.Export(context.TODO(), newTestCredentials()); err != nil {
+		t.Fatalf("unexpected error exporting: %v", err)
+	}
+
+	expected := map[string]string{keyToken: "token", keyCACrt: "ca", keyNamespace: "test-ns", keyKubeconfig: "kubeconfig"}
+	assertStringMap(t, "data", v.data["kid/app"], expected)
+
+	expected = map[string]string{metadataIdentity: "app", metadataNamespace: "test-ns", metadataTokenVersion: "3"}
+	assertStringMap(t, "custom metadata", v.metadata["kid/app"], expected)
+}
+
+func TestExportEncryptionNotSupported(t *testing.T) {
+	o := identity.ExporterOptions{Encode: func(d []byte) ([]byte, error) { return d, nil }}
+	if _, err := identity.NewExporter(context.TODO(), "vault://secret/kid/app", o); err == nil {
+		t.Errorf("expected error exporting encrypted credentials to Vault")
+	}
+}
+
+func TestExportFormatNotSupported(t *testing.T) {
+	o := identity.ExporterOptions{Format: identity.FormatGitHubActions}
+	if _, err := identity.NewExporter(context.TODO(), "vault://secret/kid/app", o); err == nil {
+		t.Errorf("expected error exporting to Vault with format '%s'", o.Format)
+	}
+}
+
+func assertStringMap(t *testing.T, name string, found map[string]string, expected map[string]string) {
+	t.Helper()
+
+	if len(found) != len(expected) {
+		t.Errorf("expected %s %v, found %v", name, expected, found)
+		return
+	}
+	for k, v := range expected {
+		if found[k] != v {
+			t.Errorf("expected %s '%s' to be '%s', found '%s'", name, k, v, found[k])
+		}
+	}
+}